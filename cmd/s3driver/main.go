@@ -20,8 +20,13 @@ import (
 	"flag"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/driver"
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"golang.org/x/net/http/httpproxy"
 )
 
 func init() {
@@ -29,17 +34,84 @@ func init() {
 }
 
 var (
-	endpoint = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
-	nodeID   = flag.String("nodeid", "", "node id")
+	endpoint  = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
+	nodeID    = flag.String("nodeid", "", "node id")
+	secretDir = flag.String("secret-dir", "",
+		"directory with a mounted S3 secret, used by requests without secrets like ListSnapshots and ListVolumes")
+	trashTTL = flag.Duration("trash-ttl", 7*24*time.Hour,
+		"purge volumes deleted with onDelete: trash after this time, 0 keeps them forever. Requires --secret-dir")
+	clientIdleTimeout = flag.Duration("s3-client-idle-timeout", s3.DefaultClientIdleTimeout,
+		"keep S3 clients and their connections for reuse this long after their last request")
+	capacityCheckInterval = flag.Duration("capacity-check-interval", driver.DefaultCapacityCheckInterval,
+		"check the usage of volumes with enforceCapacity this often at most, volumes which take long to list are checked less often")
+	removeParallelism = flag.Int("remove-parallelism", 4,
+		"number of batches of objects removed at once when deleting a volume or snapshot")
+	removeBatchSize = flag.Int("remove-batch-size", 1000,
+		"number of objects removed with one request when deleting a volume or snapshot, at most 1000")
+	removeGovernanceBypass = flag.Bool("remove-governance-bypass", true,
+		"remove object versions under governance retention when deleting a volume or snapshot, false fails instead")
+	// The proxies of the environment are the defaults, so they're applied to
+	// the mounters too
+	proxyEnv  = httpproxy.FromEnvironment()
+	httpProxy = flag.String("http-proxy", proxyEnv.HTTPProxy,
+		"proxy of http requests to S3 and of the mounters, for secrets without httpProxy, httpsProxy and noProxy")
+	httpsProxy = flag.String("https-proxy", proxyEnv.HTTPSProxy,
+		"proxy of https requests to S3 and of the mounters, for secrets without httpProxy, httpsProxy and noProxy")
+	noProxy = flag.String("no-proxy", proxyEnv.NoProxy,
+		"hosts reached without --http-proxy and --https-proxy")
 )
 
 func main() {
 	flag.Parse()
 
-	d, err := driver.New(*nodeID, *endpoint)
+	opts := []driver.Option{
+		driver.WithTrashTTL(*trashTTL),
+		driver.WithClientIdleTimeout(*clientIdleTimeout),
+		driver.WithCapacityCheckInterval(*capacityCheckInterval),
+		driver.WithProxy(s3.ProxyConfig{
+			HTTPProxy:  *httpProxy,
+			HTTPSProxy: *httpsProxy,
+			NoProxy:    *noProxy,
+		}),
+		driver.WithRemoveOptions(s3.RemoveOptions{
+			Parallelism:      *removeParallelism,
+			BatchSize:        *removeBatchSize,
+			GovernanceBypass: *removeGovernanceBypass,
+		}),
+	}
+	if *secretDir != "" {
+		secrets, err := readSecretDir(*secretDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, driver.WithSecrets(secrets))
+	}
+
+	d, err := driver.New(*nodeID, *endpoint, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
 	d.Run()
 	os.Exit(0)
 }
+
+// readSecretDir reads a Kubernetes secret mounted as a volume, one file per key
+func readSecretDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]string)
+	for _, entry := range entries {
+		// Skip the ..data symlinks and directories maintained by the kubelet
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		value, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		secrets[entry.Name()] = string(value)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// A node renews the lock of a single-node volume every volumeLockRenewal.
+	// Locks older than volumeLockTTL belong to a node which is gone.
+	volumeLockRenewal = time.Minute
+	volumeLockTTL     = 5 * time.Minute
+)
+
+type volumeLocker interface {
+	GetVolumeLock(ctx context.Context, bucketName, prefix string) (*s3.VolumeLock, error)
+	SetVolumeLock(ctx context.Context, bucketName, prefix string, lock, previous *s3.VolumeLock) error
+	RemoveVolumeLock(ctx context.Context, bucketName, prefix string) error
+}
+
+func readOnlyMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// anonymousAccess tells whether the secret selects anonymous access, which
+// mounts volumes read-only and never locks them
+func anonymousAccess(secrets map[string]string) bool {
+	cfg, err := s3.ConfigFromSecret(secrets)
+	return err == nil && cfg.Anonymous
+}
+
+func singleNodeMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// unsupportedCapability returns why a volume capability is not supported, or
+// an empty string if it is
+func (d *Driver) unsupportedCapability(capability *csi.VolumeCapability) string {
+	if capability.GetBlock() != nil {
+		return "block volumes are not supported"
+	}
+	mode := capability.GetAccessMode().GetMode()
+	for _, vc := range d.GetVolumeCapabilityAccessModes() {
+		if vc.GetMode() == mode {
+			return ""
+		}
+	}
+	supported := make([]string, 0, len(d.GetVolumeCapabilityAccessModes()))
+	for _, vc := range d.GetVolumeCapabilityAccessModes() {
+		supported = append(supported, vc.GetMode().String())
+	}
+	return fmt.Sprintf("access mode %s is not supported, supported modes are %v", mode, supported)
+}
+
+// lockVolume makes this node the only one which can stage a volume. The lock
+// is written only if it is still the one read, so of two nodes staging at the
+// very same time one fails, except on endpoints without conditional writes.
+func (d *Driver) lockVolume(ctx context.Context, client volumeLocker, volumeID string) error {
+	if d.watching("lock/" + volumeID) {
+		return nil
+	}
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	lock, err := client.GetVolumeLock(ctx, bucketName, prefix)
+	if err != nil {
+		return s3Status(err, "failed to read lock of volume %s", volumeID)
+	}
+	if lock != nil && lock.NodeID != d.nodeid && time.Since(lock.RenewTime) < volumeLockTTL {
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf(
+			"volume %s is in use by node %s", volumeID, lock.NodeID))
+	}
+	if lock != nil && lock.NodeID != d.nodeid {
+		glog.Warningf("Taking over lock of volume %s from node %s, it was last renewed at %v",
+			volumeID, lock.NodeID, lock.RenewTime)
+	}
+	err = client.SetVolumeLock(ctx, bucketName, prefix, &s3.VolumeLock{NodeID: d.nodeid, RenewTime: time.Now()}, lock)
+	if s3.ClassifyError(err) == s3.ErrPreconditionFailed {
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf(
+			"volume %s is being locked by another node", volumeID))
+	}
+	if err != nil {
+		return s3Status(err, "failed to lock volume %s", volumeID)
+	}
+	d.startWatch("lock/"+volumeID, func(ctx context.Context) {
+		d.holdVolumeLock(ctx, client, volumeID)
+	})
+	return nil
+}
+
+// unlockVolume stops renewing the lock of a volume and removes it
+func (d *Driver) unlockVolume(volumeID string) {
+	d.stopWatch("lock/" + volumeID)
+}
+
+func (d *Driver) holdVolumeLock(ctx context.Context, client volumeLocker, volumeID string) {
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	ticker := time.NewTicker(volumeLockRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is done already, unlocking gets as long as a renewal
+			unlockCtx, cancel := context.WithTimeout(context.Background(), volumeLockRenewal)
+			lock, err := client.GetVolumeLock(unlockCtx, bucketName, prefix)
+			if err == nil && lock != nil && lock.NodeID == d.nodeid {
+				err = client.RemoveVolumeLock(unlockCtx, bucketName, prefix)
+			}
+			cancel()
+			if err != nil {
+				glog.Errorf("failed to unlock volume %s: %v", volumeID, err)
+			}
+			return
+		case <-ticker.C:
+		}
+		lock, err := client.GetVolumeLock(ctx, bucketName, prefix)
+		if err == nil && lock != nil && lock.NodeID != d.nodeid {
+			glog.Errorf("lock of volume %s was taken over by node %s", volumeID, lock.NodeID)
+			continue
+		}
+		if err == nil {
+			err = client.SetVolumeLock(ctx, bucketName, prefix, &s3.VolumeLock{NodeID: d.nodeid, RenewTime: time.Now()}, lock)
+		}
+		if err != nil {
+			glog.Errorf("failed to renew lock of volume %s: %v", volumeID, err)
+		}
+	}
+}
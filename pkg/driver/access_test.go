@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/minio/minio-go/v7"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeLocker keeps the lock of a volume in memory
+type fakeLocker struct {
+	mu       sync.Mutex
+	lock     *s3.VolumeLock
+	previous *s3.VolumeLock
+	getErr   error
+	setErr   error
+}
+
+func (f *fakeLocker) GetVolumeLock(_ context.Context, _, _ string) (*s3.VolumeLock, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lock, f.getErr
+}
+
+func (f *fakeLocker) SetVolumeLock(_ context.Context, _, _ string, lock, previous *s3.VolumeLock) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.setErr != nil {
+		return f.setErr
+	}
+	f.lock, f.previous = lock, previous
+	return nil
+}
+
+func (f *fakeLocker) RemoveVolumeLock(_ context.Context, _, _ string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lock = nil
+	return nil
+}
+
+var _ = DescribeTable("lockVolume",
+	func(locker *fakeLocker, code codes.Code) {
+		d := &Driver{nodeid: "node"}
+		found := locker.lock
+		err := d.lockVolume(context.Background(), locker, "bucket/pvc")
+		if code != codes.OK {
+			Expect(status.Code(err)).To(Equal(code))
+			Expect(d.watching("lock/bucket/pvc")).To(BeFalse())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locker.lock.NodeID).To(Equal("node"))
+		// Written over the lock which was read only
+		Expect(locker.previous).To(BeIdenticalTo(found))
+		Expect(d.watching("lock/bucket/pvc")).To(BeTrue())
+		d.unlockVolume("bucket/pvc")
+		Expect(locker.lock).To(BeNil())
+	},
+	Entry("unlocked", &fakeLocker{}, codes.OK),
+	Entry("locked by the node", &fakeLocker{lock: &s3.VolumeLock{NodeID: "node", RenewTime: time.Now()}}, codes.OK),
+	Entry("locked by another node",
+		&fakeLocker{lock: &s3.VolumeLock{NodeID: "other", RenewTime: time.Now()}}, codes.FailedPrecondition),
+	Entry("abandoned by another node",
+		&fakeLocker{lock: &s3.VolumeLock{NodeID: "other", RenewTime: time.Now().Add(-volumeLockTTL)}}, codes.OK),
+	Entry("locked by another node meanwhile",
+		&fakeLocker{setErr: minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: 412}}, codes.FailedPrecondition),
+	Entry("unreadable lock", &fakeLocker{getErr: minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403}}, codes.PermissionDenied),
+	Entry("unwritable lock", &fakeLocker{setErr: errors.New("unavailable")}, codes.Internal),
+)
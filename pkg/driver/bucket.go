@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Settings of the buckets created for volumes
+	versioningKey              = "versioning"
+	versioningEnabled          = "enabled"
+	objectLockKey              = "objectLock"
+	objectLockRetentionModeKey = "objectLockRetentionMode"
+	objectLockRetentionDaysKey = "objectLockRetentionDays"
+	// bucketTagsKey holds comma separated key=value pairs
+	bucketTagsKey         = "bucketTags"
+	locationConstraintKey = "locationConstraint"
+)
+
+// bucketOptions returns the bucket settings requested in the parameters of
+// a volume
+func bucketOptions(params map[string]string) (s3.BucketOptions, error) {
+	opts := s3.BucketOptions{Region: params[locationConstraintKey]}
+	switch params[versioningKey] {
+	case "":
+	case versioningEnabled:
+		opts.Versioning = true
+	default:
+		return opts, status.Error(codes.InvalidArgument, fmt.Sprintf("%s must be empty or %s", versioningKey, versioningEnabled))
+	}
+	if params[objectLockKey] != "" {
+		lock, err := strconv.ParseBool(params[objectLockKey])
+		if err != nil {
+			return opts, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", objectLockKey, err))
+		}
+		opts.ObjectLock = lock
+	}
+	if mode := params[objectLockRetentionModeKey]; mode != "" {
+		opts.RetentionMode = minio.RetentionMode(strings.ToUpper(mode))
+		if !opts.RetentionMode.IsValid() {
+			return opts, status.Error(codes.InvalidArgument, fmt.Sprintf(
+				"%s must be %s or %s", objectLockRetentionModeKey, minio.Governance, minio.Compliance))
+		}
+		days, err := strconv.ParseUint(params[objectLockRetentionDaysKey], 10, 32)
+		if err != nil || days == 0 {
+			return opts, status.Error(codes.InvalidArgument, fmt.Sprintf(
+				"%s must be a positive number of days with %s", objectLockRetentionDaysKey, objectLockRetentionModeKey))
+		}
+		opts.RetentionDays = uint(days)
+	} else if params[objectLockRetentionDaysKey] != "" {
+		return opts, status.Error(codes.InvalidArgument, fmt.Sprintf(
+			"%s requires %s", objectLockRetentionDaysKey, objectLockRetentionModeKey))
+	}
+	if opts.RetentionMode != "" && !opts.ObjectLock {
+		return opts, status.Error(codes.InvalidArgument, fmt.Sprintf(
+			"%s requires %s", objectLockRetentionModeKey, objectLockKey))
+	}
+	if params[bucketTagsKey] != "" {
+		opts.Tags = make(map[string]string)
+		for _, tag := range strings.Split(params[bucketTagsKey], ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(tag), "=")
+			if !ok || k == "" {
+				return opts, status.Error(codes.InvalidArgument, fmt.Sprintf(
+					"%s must be comma separated key=value pairs", bucketTagsKey))
+			}
+			opts.Tags[k] = v
+		}
+		if _, err := tags.MapToBucketTags(opts.Tags); err != nil {
+			return opts, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", bucketTagsKey, err))
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/minio/minio-go/v7"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = DescribeTable("bucketOptions",
+	func(params map[string]string, opts s3.BucketOptions) {
+		o, err := bucketOptions(params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o).To(Equal(opts))
+	},
+	Entry("none", map[string]string{}, s3.BucketOptions{}),
+	Entry("region", map[string]string{"locationConstraint": "eu-west-1"}, s3.BucketOptions{Region: "eu-west-1"}),
+	Entry("versioning", map[string]string{"versioning": "enabled"}, s3.BucketOptions{Versioning: true}),
+	Entry("object lock", map[string]string{"objectLock": "true"}, s3.BucketOptions{ObjectLock: true}),
+	Entry("no object lock", map[string]string{"objectLock": "false"}, s3.BucketOptions{}),
+	Entry("retention",
+		map[string]string{"objectLock": "true", "objectLockRetentionMode": "governance", "objectLockRetentionDays": "7"},
+		s3.BucketOptions{ObjectLock: true, RetentionMode: minio.Governance, RetentionDays: 7}),
+	Entry("tags", map[string]string{"bucketTags": "team=storage, env=prod"},
+		s3.BucketOptions{Tags: map[string]string{"team": "storage", "env": "prod"}}),
+)
+
+var _ = DescribeTable("bucketOptions with invalid parameters",
+	func(params map[string]string) {
+		_, err := bucketOptions(params)
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	},
+	Entry("versioning", map[string]string{"versioning": "suspended"}),
+	Entry("object lock", map[string]string{"objectLock": "yes please"}),
+	Entry("retention mode", map[string]string{"objectLock": "true", "objectLockRetentionMode": "forever", "objectLockRetentionDays": "7"}),
+	Entry("retention without days", map[string]string{"objectLock": "true", "objectLockRetentionMode": "compliance"}),
+	Entry("retention of no days", map[string]string{"objectLock": "true", "objectLockRetentionMode": "compliance", "objectLockRetentionDays": "0"}),
+	Entry("days without a mode", map[string]string{"objectLock": "true", "objectLockRetentionDays": "7"}),
+	Entry("retention without object lock", map[string]string{"objectLockRetentionMode": "compliance", "objectLockRetentionDays": "7"}),
+	Entry("tag without a value", map[string]string{"bucketTags": "team"}),
+	Entry("tag without a key", map[string]string{"bucketTags": "=storage"}),
+)
@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/mounter"
+	"github.com/golang/glog"
+)
+
+const (
+	// enforceCapacityKey makes the node remount a volume read-only while its
+	// usage exceeds its capacity, for mounters which can't limit it themselves
+	enforceCapacityKey = "enforceCapacity"
+
+	// DefaultCapacityCheckInterval is how often the usage of a volume is
+	// checked at most
+	DefaultCapacityCheckInterval = time.Minute
+	// capacityCheckShare is the largest share of the time spent listing a
+	// volume, larger volumes are checked less often
+	capacityCheckShare = 10
+)
+
+type usageGetter interface {
+	fsMetaGetter
+	PrefixUsage(ctx context.Context, bucketName, prefix string) (int64, error)
+}
+
+func enforceCapacityEnabled(volumeCtx map[string]string) bool {
+	enabled, _ := strconv.ParseBool(volumeCtx[enforceCapacityKey])
+	return enabled
+}
+
+// watchCapacity starts enforcing the capacity of a staged volume, unless
+// that is already done
+func (d *Driver) watchCapacity(client usageGetter, volumeID, stagingTargetPath string) {
+	d.startWatch("capacity/"+volumeID, func(ctx context.Context) {
+		enforceCapacity(ctx, client, volumeID, stagingTargetPath, d.capacityCheckInterval)
+	})
+}
+
+func (d *Driver) unwatchCapacity(volumeID string) {
+	d.stopWatch("capacity/" + volumeID)
+}
+
+// enforceCapacity periodically compares the usage of a volume with the
+// capacity in its metadata object, which is re-read every time to pick up
+// expansions. Deleting files is a write too, so a full volume stays read-only
+// until it is expanded. Usage is summed up by listing the whole volume, so
+// volumes which take long to list are checked less often than interval.
+func enforceCapacity(ctx context.Context, client usageGetter, volumeID, stagingTargetPath string, interval time.Duration) {
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	applied, readOnly := false, false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		start := time.Now()
+		full, err := volumeFull(ctx, client, bucketName, prefix)
+		timer.Reset(max(interval, capacityCheckShare*time.Since(start)))
+		if err != nil {
+			glog.Errorf("failed to check usage of volume %s: %v", volumeID, err)
+		} else if !applied || full != readOnly {
+			if err = mounter.SetReadOnly(stagingTargetPath, full); err != nil {
+				glog.Errorf("failed to remount volume %s with read-only=%v: %v", volumeID, full, err)
+			} else {
+				if full {
+					glog.Warningf("volume %s exceeds its capacity, remounted read-only", volumeID)
+				} else if applied {
+					glog.Infof("volume %s is within its capacity again, remounted read-write", volumeID)
+				}
+				applied, readOnly = true, full
+			}
+		}
+	}
+}
+
+func volumeFull(ctx context.Context, client usageGetter, bucketName, prefix string) (bool, error) {
+	meta, err := client.GetFSMeta(ctx, bucketName, prefix)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil || meta.CapacityBytes <= 0 {
+		return false, nil
+	}
+	usage, err := client.PrefixUsage(ctx, bucketName, prefix)
+	if err != nil {
+		return false, err
+	}
+	return usage > meta.CapacityBytes, nil
+}
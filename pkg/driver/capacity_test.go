@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"context"
+	"errors"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fakeUsage is a volume with the given metadata and usage
+type fakeUsage struct {
+	fakeMeta
+	usage    int64
+	usageErr error
+}
+
+func (f *fakeUsage) PrefixUsage(_ context.Context, _, _ string) (int64, error) {
+	return f.usage, f.usageErr
+}
+
+var _ = DescribeTable("volumeFull",
+	func(client *fakeUsage, full, valid bool) {
+		f, err := volumeFull(context.Background(), client, "bucket", "pvc")
+		if !valid {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f).To(Equal(full))
+	},
+	Entry("below the capacity", &fakeUsage{fakeMeta: fakeMeta{meta: &s3.FSMeta{CapacityBytes: 10}}, usage: 5}, false, true),
+	Entry("at the capacity", &fakeUsage{fakeMeta: fakeMeta{meta: &s3.FSMeta{CapacityBytes: 10}}, usage: 10}, false, true),
+	Entry("above the capacity", &fakeUsage{fakeMeta: fakeMeta{meta: &s3.FSMeta{CapacityBytes: 10}}, usage: 11}, true, true),
+	Entry("without a capacity", &fakeUsage{fakeMeta: fakeMeta{meta: &s3.FSMeta{}}, usage: 11}, false, true),
+	Entry("without metadata", &fakeUsage{usage: 11}, false, true),
+	Entry("unreadable metadata", &fakeUsage{fakeMeta: fakeMeta{err: errors.New("unavailable")}}, false, false),
+	Entry("unlistable volume",
+		&fakeUsage{fakeMeta: fakeMeta{meta: &s3.FSMeta{CapacityBytes: 10}}, usageErr: errors.New("unavailable")}, false, false),
+)
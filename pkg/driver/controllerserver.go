@@ -22,7 +22,9 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/mounter"
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
@@ -30,12 +32,25 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
 
-func (d *Driver) CreateVolume(_ context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+const (
+	// onDeleteKey selects what DeleteVolume does with the data of a volume
+	onDeleteKey    = "onDelete"
+	onDeleteDelete = "delete"
+	onDeleteRetain = "retain"
+	onDeleteTrash  = "trash"
+
+	// quotaKey selects how the capacity of a volume is enforced by the backend
+	quotaKey   = "quota"
+	quotaMinio = "minio"
+)
+
+func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	params := req.GetParameters()
 	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
 	volumeID := sanitizeVolumeID(req.GetName())
@@ -60,47 +75,177 @@ func (d *Driver) CreateVolume(_ context.Context, req *csi.CreateVolumeRequest) (
 	if req.GetVolumeCapabilities() == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
 	}
+	for _, capability := range req.GetVolumeCapabilities() {
+		if msg := d.unsupportedCapability(capability); msg != "" {
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+	}
+
+	if _, err := onDeletePolicy(params); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	switch params[quotaKey] {
+	case "":
+	case quotaMinio:
+		if prefix != "" {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf(
+				"%s=%s can't be used together with %s", quotaKey, quotaMinio, mounter.BucketKey))
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("%s must be empty or %s", quotaKey, quotaMinio))
+	}
+
+	bucketOpts, err := bucketOptions(params)
+	if err != nil {
+		return nil, err
+	}
 
 	glog.V(4).Infof("Got a request to create volume %s", volumeID)
 
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+	if client.Config.Anonymous {
+		return nil, status.Error(codes.InvalidArgument, "anonymous access is read-only, use a statically provisioned volume")
+	}
+	enc, err := s3.VolumeEncryption(params, client.Config)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	bucketOpts.Encryption = enc
+	mounterType := params[mounter.TypeKey]
+	if mounterType == "" {
+		mounterType = client.Config.Mounter
+	}
+	if err := mounter.CheckCrypt(params, mounterType); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := mounter.CheckEncryption(params, mounterType); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	exists, err := client.BucketExists(bucketName)
+	existing, err := client.GetFSMeta(ctx, bucketName, prefix)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check if bucket %s exists: %v", volumeID, err)
+		return nil, s3Status(err, "failed to read metadata of volume %s", volumeID)
+	}
+	if existing != nil {
+		// A retried request is compatible with a volume expanded since
+		limit := req.GetCapacityRange().GetLimitBytes()
+		if existing.CapacityBytes < capacityBytes || (limit > 0 && existing.CapacityBytes > limit) {
+			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf(
+				"volume %s already exists with an incompatible capacity %d", volumeID, existing.CapacityBytes))
+		}
+		capacityBytes = existing.CapacityBytes
 	}
 
-	if !exists {
-		if err = client.CreateBucket(bucketName); err != nil {
-			return nil, fmt.Errorf("failed to create bucket %s: %v", bucketName, err)
+	// Resolve the source first to not leave an empty volume behind if it's missing
+	srcBucket, srcPrefix := "", ""
+	var srcSize int64
+	if snapshot := req.GetVolumeContentSource().GetSnapshot(); snapshot != nil {
+		if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+			return nil, err
+		}
+		srcBucket, srcPrefix = volumeIDToBucketPrefix(snapshot.GetSnapshotId())
+		meta, err := client.GetSnapshotMeta(ctx, srcBucket, srcPrefix)
+		if err != nil {
+			return nil, s3Status(err, "failed to read metadata of snapshot %s", snapshot.GetSnapshotId())
+		}
+		if meta == nil {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf(
+				"source snapshot %s does not exist", snapshot.GetSnapshotId()))
+		}
+		srcSize = meta.SizeBytes
+	} else if volume := req.GetVolumeContentSource().GetVolume(); volume != nil {
+		if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CLONE_VOLUME); err != nil {
+			return nil, err
+		}
+		srcBucket, srcPrefix = volumeIDToBucketPrefix(volume.GetVolumeId())
+		meta, err := client.GetFSMeta(ctx, srcBucket, srcPrefix)
+		exists := meta != nil
+		if err == nil && meta == nil {
+			// Volumes created by older versions have no metadata object yet
+			if srcPrefix == "" {
+				exists, err = client.BucketExists(ctx, srcBucket)
+			} else {
+				exists, err = client.PrefixExists(ctx, srcBucket, srcPrefix)
+			}
+		}
+		if err != nil && !s3.IsNotFound(err) {
+			return nil, s3Status(err, "failed to check if source volume %s exists", volume.GetVolumeId())
+		}
+		if !exists {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf(
+				"source volume %s does not exist", volume.GetVolumeId()))
+		}
+		if meta != nil {
+			srcSize = meta.CapacityBytes
 		}
 	}
+	capacityBytes, err = copyCapacity(capacityBytes, req.GetCapacityRange().GetLimitBytes(), srcSize)
+	if err != nil {
+		return nil, status.Error(codes.OutOfRange, fmt.Sprintf("volume %s %v", volumeID, err))
+	}
+	if srcBucket == bucketName && (srcPrefix == "" || prefix == "") {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf(
+			"volume %s can't be copied to or from a prefix of its own bucket", volumeID))
+	}
 
-	if err = client.CreatePrefix(bucketName, prefix); err != nil {
-		return nil, fmt.Errorf("failed to create prefix %s: %v", prefix, err)
+	exists, err := client.BucketExists(ctx, bucketName)
+	if err != nil {
+		return nil, s3Status(err, "failed to check if bucket %s exists", volumeID)
 	}
 
-	glog.V(4).Infof("create volume %s", volumeID)
-	// DeleteVolume lacks VolumeContext, but publish&unpublish requests have it,
-	// so we don't need to store additional metadata anywhere
-	ctx := make(map[string]string)
+	if !exists || prefix == "" {
+		// A bucket of the volume itself exists already if the request is
+		// retried, its settings are applied again
+		if err = client.CreateBucket(ctx, bucketName, bucketOpts); err != nil {
+			return nil, s3Status(err, "failed to create bucket %s", bucketName)
+		}
+	} else if err = client.CheckBucket(ctx, bucketName, bucketOpts); err != nil {
+		return nil, s3Status(err, "failed to check bucket %s", bucketName)
+	}
+
+	if err = client.CreatePrefix(ctx, bucketName, prefix); err != nil {
+		return nil, s3Status(err, "failed to create prefix %s", prefix)
+	}
+
+	if srcBucket != "" {
+		glog.V(4).Infof("Copying %s to volume %s", path.Join(srcBucket, srcPrefix), volumeID)
+		if _, err = client.CopyPrefix(ctx, srcBucket, srcPrefix, bucketName, prefix, enc); err != nil {
+			return nil, s3Status(err, "failed to copy %s to volume %s", path.Join(srcBucket, srcPrefix), volumeID)
+		}
+	}
+
+	if params[quotaKey] == quotaMinio && capacityBytes > 0 {
+		if err = client.SetMinioBucketQuota(ctx, bucketName, capacityBytes); err != nil {
+			return nil, s3Status(err, "failed to set quota of volume %s", volumeID)
+		}
+	}
+
+	// DeleteVolume lacks VolumeContext and static PVs may lack it too,
+	// so the volume context is also kept in the volume's metadata object
+	volumeCtx := make(map[string]string)
 	for k, v := range params {
-		ctx[k] = v
+		volumeCtx[k] = v
+	}
+	volumeCtx["capacity"] = fmt.Sprintf("%v", capacityBytes)
+	if err = client.SetFSMeta(ctx, getMeta(bucketName, prefix, volumeCtx)); err != nil {
+		return nil, s3Status(err, "failed to write metadata of volume %s", volumeID)
 	}
-	ctx["capacity"] = fmt.Sprintf("%v", capacityBytes)
+
+	glog.V(4).Infof("create volume %s", volumeID)
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volumeID,
 			CapacityBytes: capacityBytes,
-			VolumeContext: ctx,
+			VolumeContext: volumeCtx,
+			ContentSource: req.GetVolumeContentSource(),
 		},
 	}, nil
 }
 
-func (d *Driver) DeleteVolume(_ context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
 
@@ -115,23 +260,59 @@ func (d *Driver) DeleteVolume(_ context.Context, req *csi.DeleteVolumeRequest) (
 	}
 	glog.V(4).Infof("Deleting volume %s", volumeID)
 
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+
+	meta, err := client.GetFSMeta(ctx, bucketName, prefix)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+		return nil, s3Status(err, "failed to read metadata of volume %s", volumeID)
+	}
+	onDelete := onDeleteDelete
+	if meta != nil {
+		glog.V(4).Infof("Volume %s was created with mounter %q and capacity %d",
+			volumeID, meta.Mounter, meta.CapacityBytes)
+		if onDelete, err = onDeletePolicy(meta.Parameters); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("metadata of volume %s: %v", volumeID, err))
+		}
 	}
 
 	var deleteErr error
-	if prefix == "" {
+	if onDelete == onDeleteRetain {
+		glog.V(4).Infof("Retaining data of volume %s", volumeID)
+	} else if onDelete == onDeleteTrash {
+		enc, err := s3.VolumeEncryption(meta.Parameters, client.Config)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		err = d.removeInBackground(ctx, volumeID, func(ctx context.Context, opts s3.RemoveOptions) error {
+			return client.MoveToTrash(ctx, bucketName, prefix, time.Now(), enc, opts)
+		})
+		if err != nil && !s3.IsNotFound(err) {
+			deleteErr = s3Status(err, "unable to move volume to trash")
+		} else if err == nil {
+			glog.V(4).Infof("Volume %s moved to trash", volumeID)
+		}
+	} else if prefix == "" {
 		// prefix is empty, we delete the whole bucket
-		if err := client.RemoveBucket(bucketName); err != nil && err.Error() != "The specified bucket does not exist" {
-			deleteErr = err
+		err := d.removeInBackground(ctx, volumeID, func(ctx context.Context, opts s3.RemoveOptions) error {
+			return client.RemoveBucket(ctx, bucketName, opts)
+		})
+		if err != nil && !s3.IsNotFound(err) {
+			deleteErr = s3Status(err, "unable to remove bucket")
+		} else if err == nil {
+			glog.V(4).Infof("Bucket %s removed", bucketName)
 		}
-		glog.V(4).Infof("Bucket %s removed", bucketName)
 	} else {
-		if err := client.RemovePrefix(bucketName, prefix); err != nil {
-			deleteErr = fmt.Errorf("unable to remove prefix: %w", err)
+		err := d.removeInBackground(ctx, volumeID, func(ctx context.Context, opts s3.RemoveOptions) error {
+			return client.RemovePrefix(ctx, bucketName, prefix, opts)
+		})
+		if err != nil && !s3.IsNotFound(err) {
+			deleteErr = s3Status(err, "unable to remove prefix")
+		} else if err == nil {
+			glog.V(4).Infof("Prefix %s removed", prefix)
 		}
-		glog.V(4).Infof("Prefix %s removed", prefix)
 	}
 
 	if deleteErr != nil {
@@ -142,7 +323,7 @@ func (d *Driver) DeleteVolume(_ context.Context, req *csi.DeleteVolumeRequest) (
 }
 
 func (d *Driver) ValidateVolumeCapabilities(
-	_ context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
@@ -152,13 +333,13 @@ func (d *Driver) ValidateVolumeCapabilities(
 	}
 	bucketName, _ := volumeIDToBucketPrefix(req.GetVolumeId())
 
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
 	}
-	exists, err := client.BucketExists(bucketName)
+	exists, err := client.BucketExists(ctx, bucketName)
 	if err != nil {
-		return nil, err
+		return nil, s3Status(err, "failed to check if bucket %s exists", bucketName)
 	}
 
 	if !exists {
@@ -166,31 +347,93 @@ func (d *Driver) ValidateVolumeCapabilities(
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("bucket of volume with id %s does not exist", req.GetVolumeId()))
 	}
 
-	supportedAccessMode := &csi.VolumeCapability_AccessMode{
-		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
-	}
-
-	for _, capability := range req.VolumeCapabilities {
-		if capability.GetAccessMode().GetMode() != supportedAccessMode.GetMode() {
-			return &csi.ValidateVolumeCapabilitiesResponse{Message: "Only single node writer is supported"}, nil
+	for _, capability := range req.GetVolumeCapabilities() {
+		if msg := d.unsupportedCapability(capability); msg != "" {
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, nil
 		}
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: []*csi.VolumeCapability{
-				{
-					AccessMode: supportedAccessMode,
-				},
-			},
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
 		},
 	}, nil
 }
 
 func (d *Driver) ControllerExpandVolume(
-	_ context.Context, _ *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return &csi.ControllerExpandVolumeResponse{}, status.Error(codes.Unimplemented,
-		"ControllerExpandVolume is not implemented")
+	ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Capacity range missing in request")
+	}
+	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit > 0 && capacityBytes > limit {
+		return nil, status.Error(codes.OutOfRange, fmt.Sprintf(
+			"required capacity %d exceeds limit %d", capacityBytes, limit))
+	}
+
+	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
+		glog.V(3).Infof("invalid expand volume req: %v", req)
+		return nil, err
+	}
+
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	meta, err := client.GetFSMeta(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, s3Status(err, "failed to read metadata of volume %s", volumeID)
+	}
+	if meta == nil {
+		// Volumes created by older versions have no metadata object yet
+		exists, err := client.BucketExists(ctx, bucketName)
+		if err != nil {
+			return nil, s3Status(err, "failed to check if bucket %s exists", bucketName)
+		}
+		if !exists {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s does not exist", volumeID))
+		}
+		meta = getMeta(bucketName, prefix, map[string]string{})
+	}
+
+	// S3 storage can't shrink in any meaningful way, keep the larger size
+	if capacityBytes <= meta.CapacityBytes {
+		glog.V(4).Infof("Volume %s already has capacity %d", volumeID, meta.CapacityBytes)
+		return &csi.ControllerExpandVolumeResponse{CapacityBytes: meta.CapacityBytes}, nil
+	}
+
+	glog.V(4).Infof("Expanding volume %s from %d to %d bytes", volumeID, meta.CapacityBytes, capacityBytes)
+	if meta.Parameters[quotaKey] == quotaMinio && prefix == "" {
+		if err = client.SetMinioBucketQuota(ctx, bucketName, capacityBytes); err != nil {
+			return nil, s3Status(err, "failed to set quota of volume %s", volumeID)
+		}
+	}
+	meta.CapacityBytes = capacityBytes
+	if meta.Parameters != nil {
+		meta.Parameters["capacity"] = fmt.Sprintf("%v", capacityBytes)
+	}
+	if err = client.SetFSMeta(ctx, meta); err != nil {
+		return nil, s3Status(err, "failed to write metadata of volume %s", volumeID)
+	}
+
+	// rclone and s3fs only learn the size when the volume is staged again,
+	// see NodeExpandVolume. Capacity enforcement re-reads the metadata.
+	mounterType := meta.Mounter
+	if mounterType == "" {
+		mounterType = client.Config.Mounter
+	}
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacityBytes,
+		NodeExpansionRequired: mounter.ShowsCapacity(mounterType),
+	}, nil
 }
 
 func sanitizeVolumeID(volumeID string) string {
@@ -250,8 +493,46 @@ func (d *Driver) ControllerUnpublishVolume(
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
-func (d *Driver) ListVolumes(_ context.Context, _ *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_VOLUMES); err != nil {
+		glog.V(3).Infof("Invalid list volumes req: %v", req)
+		return nil, err
+	}
+
+	// ListVolumes requests never carry secrets
+	client, err := d.clients.ClientFromSecret(d.secrets)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+
+	volumes, err := client.ListVolumes(ctx)
+	if err != nil {
+		return nil, s3Status(err, "failed to list volumes")
+	}
+	volumeIDs := make([]string, 0, len(volumes))
+	for volumeID := range volumes {
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+	sort.Strings(volumeIDs)
+
+	start, end, nextToken, err := paginate(len(volumeIDs), req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, end-start)
+	for _, volumeID := range volumeIDs[start:end] {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      volumeID,
+				CapacityBytes: volumes[volumeID].CapacityBytes,
+			},
+		})
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
 func (d *Driver) GetCapacity(_ context.Context, _ *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
@@ -262,14 +543,261 @@ func (d *Driver) GetCapacity(_ context.Context, _ *csi.GetCapacityRequest) (*csi
 	}, nil
 }
 
-func (d *Driver) CreateSnapshot(_ context.Context, _ *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	sourceVolumeID := req.GetSourceVolumeId()
+	snapshotName := sanitizeVolumeID(req.GetName())
+
+	// Check arguments
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot name missing in request")
+	}
+	if snapshotName == "" {
+		return nil, status.Error(codes.InvalidArgument, "Unable to sanitise snapshot name")
+	}
+	if len(sourceVolumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID missing in request")
+	}
+
+	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		glog.V(3).Infof("invalid create snapshot req: %v", req)
+		return nil, err
+	}
+
+	srcBucket, srcPrefix := volumeIDToBucketPrefix(sourceVolumeID)
+	bucketName, prefix := snapshotLocation(snapshotName, srcBucket, srcPrefix, req.GetParameters())
+	snapshotID := path.Join(bucketName, prefix)
+	if bucketName == srcBucket && srcPrefix == "" {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf(
+			"snapshot of volume %s can't be stored in the volume's own bucket", sourceVolumeID))
+	}
+	glog.V(4).Infof("Got a request to create snapshot %s of volume %s", snapshotID, sourceVolumeID)
+
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+
+	meta, err := client.GetSnapshotMeta(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, s3Status(err, "failed to check if snapshot %s exists", snapshotID)
+	}
+	if meta != nil {
+		if meta.SourceVolumeID != sourceVolumeID {
+			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf(
+				"snapshot %s already exists for a different volume %s", snapshotID, meta.SourceVolumeID))
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: newCSISnapshot(snapshotID, meta)}, nil
+	}
+
+	exists, err := client.BucketExists(ctx, srcBucket)
+	if err != nil {
+		return nil, s3Status(err, "failed to check if bucket %s exists", srcBucket)
+	}
+	if !exists {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("source volume %s does not exist", sourceVolumeID))
+	}
+
+	exists, err = client.BucketExists(ctx, bucketName)
+	if err != nil {
+		return nil, s3Status(err, "failed to check if bucket %s exists", bucketName)
+	}
+	if !exists {
+		if err = client.CreateBucket(ctx, bucketName, s3.BucketOptions{}); err != nil {
+			return nil, s3Status(err, "failed to create bucket %s", bucketName)
+		}
+	}
+
+	srcMeta, err := client.GetFSMeta(ctx, srcBucket, srcPrefix)
+	if err != nil {
+		return nil, s3Status(err, "failed to read metadata of volume %s", sourceVolumeID)
+	}
+	// Snapshots are encrypted like their volume
+	var enc *s3.Encryption
+	if srcMeta != nil {
+		if enc, err = s3.VolumeEncryption(srcMeta.Parameters, client.Config); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	size, err := client.CopyPrefix(ctx, srcBucket, srcPrefix, bucketName, prefix, enc)
+	if err != nil {
+		return nil, s3Status(err, "failed to copy volume %s to snapshot %s", sourceVolumeID, snapshotID)
+	}
+	// Restored volumes should be as large as their source
+	if srcMeta != nil && srcMeta.CapacityBytes > 0 {
+		size = srcMeta.CapacityBytes
+	}
+
+	// Metadata is written last, so it only exists for complete snapshots
+	meta = &s3.SnapshotMeta{
+		SourceVolumeID: sourceVolumeID,
+		CreationTime:   time.Now().UTC(),
+		SizeBytes:      size,
+	}
+	if err = client.SetSnapshotMeta(ctx, bucketName, prefix, meta); err != nil {
+		return nil, s3Status(err, "failed to write metadata of snapshot %s", snapshotID)
+	}
+
+	glog.V(4).Infof("create snapshot %s", snapshotID)
+	return &csi.CreateSnapshotResponse{Snapshot: newCSISnapshot(snapshotID, meta)}, nil
 }
 
-func (d *Driver) DeleteSnapshot(_ context.Context, _ *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	snapshotID := req.GetSnapshotId()
+	bucketName, prefix := volumeIDToBucketPrefix(snapshotID)
+
+	// Check arguments
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID missing in request")
+	}
+
+	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		glog.V(3).Infof("Invalid delete snapshot req: %v", req)
+		return nil, err
+	}
+	glog.V(4).Infof("Deleting snapshot %s", snapshotID)
+
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+
+	// Never remove anything which isn't a snapshot, e.g. a volume
+	meta, err := client.GetSnapshotMeta(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, s3Status(err, "failed to read metadata of snapshot %s", snapshotID)
+	}
+	// Its metadata is removed last, so a removal which was interrupted is
+	// resumed. It may be gone while the removal finishes.
+	if meta == nil && !d.removing(snapshotID) {
+		glog.V(4).Infof("Snapshot %s does not exist", snapshotID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	if prefix == "" {
+		err := d.removeInBackground(ctx, snapshotID, func(ctx context.Context, opts s3.RemoveOptions) error {
+			return client.RemoveBucket(ctx, bucketName, opts)
+		})
+		if err != nil && !s3.IsNotFound(err) {
+			return nil, s3Status(err, "unable to remove bucket")
+		} else if err == nil {
+			glog.V(4).Infof("Bucket %s removed", bucketName)
+		}
+	} else {
+		err := d.removeInBackground(ctx, snapshotID, func(ctx context.Context, opts s3.RemoveOptions) error {
+			return client.RemovePrefix(ctx, bucketName, prefix, opts)
+		})
+		if err != nil && !s3.IsNotFound(err) {
+			return nil, s3Status(err, "unable to remove prefix")
+		} else if err == nil {
+			glog.V(4).Infof("Prefix %s removed", prefix)
+		}
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
-func (d *Driver) ListSnapshots(_ context.Context, _ *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS); err != nil {
+		glog.V(3).Infof("Invalid list snapshots req: %v", req)
+		return nil, err
+	}
+
+	client, err := d.clients.ClientFromSecret(d.secretsOr(req.GetSecrets()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+
+	snapshots := make(map[string]*s3.SnapshotMeta)
+	if req.GetSnapshotId() != "" {
+		bucketName, prefix := volumeIDToBucketPrefix(req.GetSnapshotId())
+		meta, err := client.GetSnapshotMeta(ctx, bucketName, prefix)
+		if err != nil {
+			return nil, s3Status(err, "failed to read metadata of snapshot %s", req.GetSnapshotId())
+		}
+		if meta != nil {
+			snapshots[req.GetSnapshotId()] = meta
+		}
+	} else {
+		snapshots, err = client.ListSnapshots(ctx)
+		if err != nil {
+			return nil, s3Status(err, "failed to list snapshots")
+		}
+	}
+
+	snapshotIDs := make([]string, 0, len(snapshots))
+	for snapshotID, meta := range snapshots {
+		if req.GetSourceVolumeId() == "" || req.GetSourceVolumeId() == meta.SourceVolumeID {
+			snapshotIDs = append(snapshotIDs, snapshotID)
+		}
+	}
+	sort.Strings(snapshotIDs)
+
+	start, end, nextToken, err := paginate(len(snapshotIDs), req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, snapshotID := range snapshotIDs[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: newCSISnapshot(snapshotID, snapshots[snapshotID]),
+		})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// copyCapacity returns the capacity of a volume copied from a source of
+// srcSize bytes, which it must hold entirely. The source size is used if no
+// capacity is requested, zero if it is unknown.
+func copyCapacity(capacityBytes, limitBytes, srcSize int64) (int64, error) {
+	if srcSize <= 0 {
+		return capacityBytes, nil
+	}
+	if capacityBytes == 0 {
+		capacityBytes = srcSize
+	}
+	if capacityBytes < srcSize || (limitBytes > 0 && limitBytes < srcSize) {
+		return 0, fmt.Errorf("can't be smaller than its source of %d bytes", srcSize)
+	}
+	return capacityBytes, nil
+}
+
+// onDeletePolicy returns what DeleteVolume does with the data of a volume
+// created with params
+func onDeletePolicy(params map[string]string) (string, error) {
+	switch policy := params[onDeleteKey]; policy {
+	case "":
+		return onDeleteDelete, nil
+	case onDeleteDelete, onDeleteRetain, onDeleteTrash:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%s must be one of %s, %s or %s", onDeleteKey, onDeleteDelete, onDeleteRetain, onDeleteTrash)
+	}
+}
+
+// snapshotLocation returns the bucket and prefix for a snapshot of the volume
+// stored in srcBucket/srcPrefix. Snapshots go to the bucket set in the snapshot
+// class or next to their volume, and get their own bucket if the volume is a
+// whole bucket.
+func snapshotLocation(snapshotName, srcBucket, srcPrefix string, params map[string]string) (string, string) {
+	if params[mounter.BucketKey] != "" {
+		return params[mounter.BucketKey], snapshotName
+	}
+	if srcPrefix != "" {
+		return srcBucket, snapshotName
+	}
+	return snapshotName, ""
+}
+
+func newCSISnapshot(snapshotID string, meta *s3.SnapshotMeta) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     snapshotID,
+		SourceVolumeId: meta.SourceVolumeID,
+		SizeBytes:      meta.SizeBytes,
+		CreationTime:   timestamppb.New(meta.CreationTime),
+		ReadyToUse:     true,
+	}
 }
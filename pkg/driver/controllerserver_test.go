@@ -0,0 +1,55 @@
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("snapshotLocation",
+	func(srcBucket, srcPrefix string, params map[string]string, bucket, prefix string) {
+		b, p := snapshotLocation("snap", srcBucket, srcPrefix, params)
+		Expect([]string{b, p}).To(Equal([]string{bucket, prefix}))
+	},
+	Entry("volume in a prefix", "bucket", "pvc", map[string]string{}, "bucket", "snap"),
+	Entry("volume in its own bucket", "pvc", "", map[string]string{}, "snap", ""),
+	Entry("bucket of the class", "bucket", "pvc", map[string]string{"bucket": "snapshots"}, "snapshots", "snap"),
+	Entry("bucket of the class for a whole bucket", "pvc", "", map[string]string{"bucket": "snapshots"}, "snapshots", "snap"),
+	Entry("no parameters", "pvc", "", nil, "snap", ""),
+)
+
+var _ = DescribeTable("onDeletePolicy",
+	func(params map[string]string, policy string, valid bool) {
+		p, err := onDeletePolicy(params)
+		if !valid {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(Equal(policy))
+	},
+	Entry("default", map[string]string{}, onDeleteDelete, true),
+	Entry("no parameters", nil, onDeleteDelete, true),
+	Entry("delete", map[string]string{"onDelete": "delete"}, onDeleteDelete, true),
+	Entry("retain", map[string]string{"onDelete": "retain"}, onDeleteRetain, true),
+	Entry("trash", map[string]string{"onDelete": "trash"}, onDeleteTrash, true),
+	Entry("unknown", map[string]string{"onDelete": "archive"}, "", false),
+	Entry("case sensitive", map[string]string{"onDelete": "Retain"}, "", false),
+)
+
+var _ = DescribeTable("copyCapacity",
+	func(capacity, limit, srcSize, result int64, valid bool) {
+		c, err := copyCapacity(capacity, limit, srcSize)
+		if !valid {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(Equal(result))
+	},
+	Entry("larger than the source", int64(20), int64(0), int64(10), int64(20), true),
+	Entry("as large as the source", int64(10), int64(10), int64(10), int64(10), true),
+	Entry("no capacity requested", int64(0), int64(0), int64(10), int64(10), true),
+	Entry("unknown source size", int64(5), int64(0), int64(0), int64(5), true),
+	Entry("smaller than the source", int64(5), int64(0), int64(10), int64(0), false),
+	Entry("limited below the source", int64(0), int64(5), int64(10), int64(0), false),
+)
@@ -17,6 +17,11 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
 )
@@ -24,6 +29,28 @@ import (
 type Driver struct {
 	endpoint string
 	nodeid   string
+	// secrets are used for RPCs which don't receive any, like ListSnapshots
+	secrets map[string]string
+	// trashTTL is how long volumes deleted with the trash policy are kept
+	trashTTL time.Duration
+	// clients are reused across requests, clientIdleTimeout is how long
+	// unused ones are kept
+	clients           *s3.ClientCache
+	clientIdleTimeout time.Duration
+	// proxy is used for secrets without one
+	proxy s3.ProxyConfig
+	// capacityCheckInterval is how often the usage of volumes with
+	// enforceCapacity is checked at most
+	capacityCheckInterval time.Duration
+
+	// removals are volumes and snapshots being removed in the background
+	removals   map[string]*removal
+	removalMu  sync.Mutex
+	removeOpts s3.RemoveOptions
+
+	// watches are background tasks of staged volumes
+	watches map[string]*watch
+	watchMu sync.Mutex
 
 	cap []*csi.ControllerServiceCapability
 	vc  []*csi.VolumeCapability_AccessMode
@@ -40,12 +67,67 @@ var (
 	driverName    = "ca.gmem.s3.csi"
 )
 
+// Option configures optional driver features
+type Option func(*Driver)
+
+// WithSecrets sets the S3 secret used when a request doesn't carry one
+func WithSecrets(secrets map[string]string) Option {
+	return func(d *Driver) {
+		d.secrets = secrets
+	}
+}
+
+// WithTrashTTL sets how long volumes deleted with the trash policy are kept
+// before they are purged. Zero keeps them forever.
+func WithTrashTTL(ttl time.Duration) Option {
+	return func(d *Driver) {
+		d.trashTTL = ttl
+	}
+}
+
+// WithClientIdleTimeout sets how long S3 clients are kept for reuse after
+// their last request
+func WithClientIdleTimeout(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.clientIdleTimeout = timeout
+	}
+}
+
+// WithProxy sets the proxy of secrets which have none
+func WithProxy(proxy s3.ProxyConfig) Option {
+	return func(d *Driver) {
+		d.proxy = proxy
+	}
+}
+
+// WithCapacityCheckInterval sets how often the usage of volumes with
+// enforceCapacity is checked at most
+func WithCapacityCheckInterval(interval time.Duration) Option {
+	return func(d *Driver) {
+		d.capacityCheckInterval = interval
+	}
+}
+
+// WithRemoveOptions sets how objects of deleted volumes and snapshots are
+// removed
+func WithRemoveOptions(opts s3.RemoveOptions) Option {
+	return func(d *Driver) {
+		d.removeOpts = opts
+	}
+}
+
 // New initializes the driver
-func New(nodeID string, endpoint string) (*Driver, error) {
+func New(nodeID string, endpoint string, opts ...Option) (*Driver, error) {
 	s3Driver := &Driver{
-		nodeid:   nodeID,
-		endpoint: endpoint,
+		nodeid:                nodeID,
+		endpoint:              endpoint,
+		clientIdleTimeout:     s3.DefaultClientIdleTimeout,
+		capacityCheckInterval: DefaultCapacityCheckInterval,
 	}
+	for _, opt := range opts {
+		opt(s3Driver)
+	}
+	s3Driver.clients = s3.NewClientCache(s3Driver.clientIdleTimeout, s3Driver.proxy)
 	return s3Driver, nil
 }
 
@@ -54,11 +136,31 @@ func (d *Driver) Run() {
 	glog.Infof("Version: %v ", vendorVersion)
 	// Initialize default library driver
 
-	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
+	controllerCaps := []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-	})
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+	}
+	// ListVolumes requests have no secrets, so the driver needs its own
+	if len(d.secrets) > 0 {
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_LIST_VOLUMES)
+	}
+	d.AddControllerServiceCapabilities(controllerCaps)
+	if d.trashTTL > 0 && len(d.secrets) > 0 {
+		d.startWatch("trash", d.sweepTrash)
+		defer d.stopWatch("trash")
+	}
+
 	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 	})
 
 	s := NewNonBlockingGRPCServer()
@@ -93,3 +195,55 @@ func (d *Driver) AddVolumeCapabilityAccessModes(
 func (d *Driver) GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	return d.vc
 }
+
+// watch is a background task, running until its context is cancelled
+type watch struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startWatch runs fn in the background under key, unless it's already running
+func (d *Driver) startWatch(key string, fn func(ctx context.Context)) {
+	d.watchMu.Lock()
+	defer d.watchMu.Unlock()
+	if _, ok := d.watches[key]; ok {
+		return
+	}
+	if d.watches == nil {
+		d.watches = make(map[string]*watch)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watch{cancel: cancel, done: make(chan struct{})}
+	d.watches[key] = w
+	go func() {
+		defer close(w.done)
+		fn(ctx)
+	}()
+}
+
+func (d *Driver) watching(key string) bool {
+	d.watchMu.Lock()
+	defer d.watchMu.Unlock()
+	_, ok := d.watches[key]
+	return ok
+}
+
+// stopWatch stops the task running under key and waits for it to return
+func (d *Driver) stopWatch(key string) {
+	d.watchMu.Lock()
+	w, ok := d.watches[key]
+	delete(d.watches, key)
+	d.watchMu.Unlock()
+	if ok {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// secretsOr returns secrets if they are set and the driver's own secret otherwise
+func (d *Driver) secretsOr(secrets map[string]string) map[string]string {
+	if len(secrets) == 0 {
+		return d.secrets
+	}
+	return secrets
+}
@@ -1,16 +1,26 @@
 package driver_test
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/driver"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	"github.com/kubernetes-csi/csi-test/pkg/sanity"
+	"github.com/kubernetes-csi/csi-test/utils"
 )
 
+// secrets match test/secret.yaml, for requests which don't carry any
+var secrets = map[string]string{
+	"accessKeyID":     "FJDSJ",
+	"secretAccessKey": "DSG643HGDS",
+	"endpoint":        "http://127.0.0.1:9000",
+}
+
 var _ = Describe("S3Driver", func() {
 
 	Context("geesefs", func() {
@@ -19,7 +29,7 @@ var _ = Describe("S3Driver", func() {
 		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 			Expect(err).NotTo(HaveOccurred())
 		}
-		driver, err := driver.New("test-node", csiEndpoint)
+		driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -46,7 +56,7 @@ var _ = Describe("S3Driver", func() {
 		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 			Expect(err).NotTo(HaveOccurred())
 		}
-		driver, err := driver.New("test-node", csiEndpoint)
+		driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -72,7 +82,7 @@ var _ = Describe("S3Driver", func() {
 		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 			Expect(err).NotTo(HaveOccurred())
 		}
-		driver, err := driver.New("test-node", csiEndpoint)
+		driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -99,7 +109,7 @@ var _ = Describe("S3Driver", func() {
 		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 			Expect(err).NotTo(HaveOccurred())
 		}
-		driver, err := driver.New("test-node", csiEndpoint)
+		driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -126,7 +136,7 @@ var _ = Describe("S3Driver", func() {
 			if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 				Expect(err).NotTo(HaveOccurred())
 			}
-			driver, err := driver.New("test-node", csiEndpoint)
+			driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -154,7 +164,7 @@ var _ = Describe("S3Driver", func() {
 			if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 				Expect(err).NotTo(HaveOccurred())
 			}
-			driver, err := driver.New("test-node", csiEndpoint)
+			driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -176,3 +186,60 @@ var _ = Describe("S3Driver", func() {
 		})
 	*/
 })
+
+// Replaces the csi-test v2 checks skipped in driver_test.go, which reject
+// capabilities newer than themselves
+var _ = Describe("Capabilities", func() {
+	socket := "/tmp/csi-caps.sock"
+	csiEndpoint := "unix://" + socket
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		Expect(err).NotTo(HaveOccurred())
+	}
+	driver, err := driver.New("test-node", csiEndpoint, driver.WithSecrets(secrets))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go driver.Run()
+
+	It("are returned by the controller server", func() {
+		conn, err := utils.Connect(csiEndpoint)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		resp, err := csi.NewControllerClient(conn).ControllerGetCapabilities(
+			context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		var types []csi.ControllerServiceCapability_RPC_Type
+		for _, c := range resp.GetCapabilities() {
+			types = append(types, c.GetRpc().GetType())
+		}
+		Expect(types).To(ConsistOf(
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		))
+	})
+
+	It("are returned by the node server", func() {
+		conn, err := utils.Connect(csiEndpoint)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		resp, err := csi.NewNodeClient(conn).NodeGetCapabilities(
+			context.Background(), &csi.NodeGetCapabilitiesRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		var types []csi.NodeServiceCapability_RPC_Type
+		for _, c := range resp.GetCapabilities() {
+			types = append(types, c.GetRpc().GetType())
+		}
+		Expect(types).To(ConsistOf(
+			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+			csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+			csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+			csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		))
+	})
+})
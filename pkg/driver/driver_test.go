@@ -20,10 +20,19 @@ import (
 	"testing"
 
 	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
 	. "github.com/onsi/gomega"
 )
 
+// outdatedSanitySpecs are the csi-test v2 specs which predate capabilities
+// of the driver, checked in driver_suite_test.go instead
+var outdatedSanitySpecs = []string{
+	"NodeGetCapabilities should return appropriate capabilities",
+	"ControllerGetCapabilities should return appropriate capabilities",
+}
+
 func TestS3Driver(t *testing.T) {
+	config.GinkgoConfig.SkipStrings = append(config.GinkgoConfig.SkipStrings, outdatedSanitySpecs...)
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "S3Driver")
 }
@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// unavailableRetryDelay is suggested to clients when the endpoint is
+// overloaded or unreachable
+const unavailableRetryDelay = 5 * time.Second
+
+var errorCodes = map[s3.ErrorKind]codes.Code{
+	s3.ErrNotFound:           codes.NotFound,
+	s3.ErrPermissionDenied:   codes.PermissionDenied,
+	s3.ErrUnavailable:        codes.Unavailable,
+	s3.ErrAlreadyOwned:       codes.AlreadyExists,
+	s3.ErrAlreadyExists:      codes.AlreadyExists,
+	s3.ErrInvalidArgument:    codes.InvalidArgument,
+	s3.ErrQuotaExceeded:      codes.ResourceExhausted,
+	s3.ErrNotEmpty:           codes.FailedPrecondition,
+	s3.ErrCanceled:           codes.Canceled,
+	s3.ErrDeadlineExceeded:   codes.DeadlineExceeded,
+	s3.ErrObjectLocked:       codes.FailedPrecondition,
+	s3.ErrBucketMismatch:     codes.FailedPrecondition,
+	s3.ErrPreconditionFailed: codes.Aborted,
+}
+
+// s3Status returns a gRPC status error for an error of an S3 request, with
+// the message formatted like fmt.Sprintf and followed by the error. Status
+// errors keep their code.
+func s3Status(err error, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...) + ": " + err.Error()
+	var st interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &st) {
+		return status.Error(st.GRPCStatus().Code(), msg)
+	}
+	code, ok := errorCodes[s3.ClassifyError(err)]
+	if !ok {
+		code = codes.Internal
+	}
+	if code != codes.Unavailable {
+		return status.Error(code, msg)
+	}
+	withRetry, detailsErr := status.New(code, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(unavailableRetryDelay),
+	})
+	if detailsErr != nil {
+		return status.Error(code, msg)
+	}
+	return withRetry.Err()
+}
@@ -19,9 +19,10 @@ package driver
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"regexp"
 	"strconv"
+	"syscall"
 
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/mounter"
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
@@ -56,10 +57,49 @@ func getMeta(bucketName, prefix string, context map[string]string) *s3.FSMeta {
 		Mounter:       context[mounter.TypeKey],
 		MountOptions:  mountOptions,
 		CapacityBytes: capacity,
+		Parameters:    context,
 	}
 }
 
-func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (
+type fsMetaGetter interface {
+	GetFSMeta(ctx context.Context, bucketName, prefix string) (*s3.FSMeta, error)
+}
+
+// volumeContext returns the volume context of a request, or the one stored in
+// the volume's metadata object if the request has none, like for static PVs
+// without volumeAttributes. The capacity is always taken from the metadata
+// object as the one in the PV is not updated on expansion.
+func volumeContext(ctx context.Context, client fsMetaGetter, bucketName, prefix string, context map[string]string) (map[string]string, error) {
+	meta, err := client.GetFSMeta(ctx, bucketName, prefix)
+	if err != nil {
+		if len(context) > 0 {
+			glog.Warningf("failed to read metadata of volume %s: %v", path.Join(bucketName, prefix), err)
+			return context, nil
+		}
+		return nil, s3Status(err, "failed to read metadata of volume %s", path.Join(bucketName, prefix))
+	}
+	if meta == nil {
+		return context, nil
+	}
+	if len(context) == 0 {
+		glog.V(4).Infof("Using volume context from metadata of volume %s", path.Join(bucketName, prefix))
+		context = meta.Parameters
+	}
+	volumeCtx := make(map[string]string, len(context)+2)
+	for k, v := range context {
+		volumeCtx[k] = v
+	}
+	if meta.CapacityBytes > 0 {
+		volumeCtx["capacity"] = strconv.FormatInt(meta.CapacityBytes, 10)
+	}
+	// Static PVs may not repeat it in their volume context
+	if enforce, ok := meta.Parameters[enforceCapacityKey]; ok {
+		volumeCtx[enforceCapacityKey] = enforce
+	}
+	return volumeCtx, nil
+}
+
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (
 	*csi.NodePublishVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	targetPath := req.GetTargetPath()
@@ -79,25 +119,52 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
 
+	if podCredentialsEnabled(req.GetVolumeContext()) {
+		return d.nodePublishPodVolume(ctx, req)
+	}
+
 	notMnt, err := checkMount(stagingTargetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if notMnt {
-		// Staged mount is dead by some reason. Revive it
+	// Without a secret the background tasks of a staged volume can only be
+	// restarted with NodeStageVolume after a restart of the driver
+	mode := req.GetVolumeCapability().GetAccessMode().GetMode()
+	// Anonymous volumes are read-only and can't be locked
+	anonymous := anonymousAccess(req.GetSecrets())
+	stageReadOnly := readOnlyMode(mode) || anonymous
+	lock := singleNodeMode(mode) && !anonymous
+	tokenFile := webIdentityFromFile(req.GetSecrets())
+	// Whether capacity is enforced is only known from the volume's metadata
+	restart := len(req.GetSecrets()) > 0 &&
+		((!stageReadOnly && !d.watching("capacity/"+volumeID)) || (lock && !d.watching("lock/"+volumeID)) ||
+			(tokenFile && !d.watching("token/"+volumeID)))
+	if notMnt || restart {
 		bucketName, prefix := volumeIDToBucketPrefix(volumeID)
-		s3Client, err := s3.NewClientFromSecret(req.GetSecrets())
+		s3Client, err := d.clients.ClientFromSecret(req.GetSecrets())
 		if err != nil {
-			return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
 		}
-		meta := getMeta(bucketName, prefix, req.VolumeContext)
-		mntr, err := mounter.New(meta, s3Client.Config)
+		volumeCtx, err := volumeContext(ctx, s3Client, bucketName, prefix, req.GetVolumeContext())
 		if err != nil {
 			return nil, err
 		}
-		if err := mntr.Mount(stagingTargetPath, volumeID); err != nil {
-			return nil, err
+		if lock {
+			if err := d.lockVolume(ctx, s3Client, volumeID); err != nil {
+				return nil, err
+			}
+		}
+		if notMnt {
+			// Staged mount is dead by some reason. Revive it
+			meta := getMeta(bucketName, prefix, volumeCtx)
+			if err := stageVolume(meta, s3Client.Config, volumeID, stagingTargetPath, stageReadOnly); err != nil {
+				return nil, err
+			}
 		}
+		if enforceCapacityEnabled(volumeCtx) && !stageReadOnly {
+			d.watchCapacity(s3Client, volumeID, stagingTargetPath)
+		}
+		d.watchWebIdentityToken(s3Client.Config, volumeID)
 	}
 
 	notMnt, err = checkMount(targetPath)
@@ -108,20 +175,81 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	// TODO: Implement readOnly & mountFlags
-	readOnly := req.GetReadonly()
+	readOnly := req.GetReadonly() || stageReadOnly
 	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
-	attrib := req.GetVolumeContext()
+	attrib := loggableContext(req.GetVolumeContext())
 
 	glog.V(4).Infof("target %v\nreadonly %v\nvolumeId %v\nattributes %v\nmountflags %v\n",
 		targetPath, readOnly, volumeID, attrib, mountFlags)
 
-	cmd := exec.Command("mount", "--bind", stagingTargetPath, targetPath)
-	cmd.Stderr = os.Stderr
-	glog.V(3).Infof("Binding volume %v from %v to %v", volumeID, stagingTargetPath, targetPath)
-	out, err := cmd.Output()
+	options := bindOptions(mountFlags, readOnly)
+	glog.V(3).Infof("Binding volume %v from %v to %v with options %v", volumeID, stagingTargetPath, targetPath, options)
+	if err := mount.New("").Mount(stagingTargetPath, targetPath, "", options); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to bind mount volume %s: %v", volumeID, err))
+	}
+
+	glog.V(4).Infof("s3: volume %s successfully mounted to %s", volumeID, targetPath)
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// bindOptions returns the options of the bind mount of a staged volume.
+// mount-utils remounts the bind mount with any further options, which leaves
+// the staged FUSE mount and its other bind mounts alone.
+func bindOptions(mountFlags []string, readOnly bool) []string {
+	options := append([]string{"bind"}, mountFlags...)
+	if readOnly {
+		options = append(options, "ro")
+	}
+	return options
+}
+
+// nodePublishPodVolume mounts a volume for a single pod with the credentials
+// of its service account, the staging path is not used
+func (d *Driver) nodePublishPodVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+
+	token, err := podToken(req.GetVolumeContext())
 	if err != nil {
-		return nil, fmt.Errorf("error running mount --bind %v %v: %s", stagingTargetPath, targetPath, out)
+		return nil, err
+	}
+	// The secret still provides the endpoint and the role
+	secretCfg, err := s3.ConfigFromSecret(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+	podCfg := podConfig(secretCfg, token)
+	notMnt, err := checkMount(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !notMnt {
+		// Republished with a renewed token, which the mounter reads when it
+		// assumes the role again
+		if err := mounter.UpdateWebIdentityToken(podCfg, podMountID(volumeID, targetPath)); err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("failed to update token of volume %s: %v", volumeID, err))
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	s3Client, err := d.clients.Client(podCfg)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	volumeCtx, err := volumeContext(ctx, s3Client, bucketName, prefix, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetVolumeCapability().GetMount().GetMountFlags()) > 0 {
+		glog.Warningf("volume %s is mounted by the pod, ignoring mount flags %v",
+			volumeID, req.GetVolumeCapability().GetMount().GetMountFlags())
+	}
+	readOnly := req.GetReadonly() || readOnlyMode(req.GetVolumeCapability().GetAccessMode().GetMode()) ||
+		s3Client.Config.Anonymous
+	meta := getMeta(bucketName, prefix, volumeCtx)
+	if err := publishPodVolume(meta, s3Client.Config, volumeID, targetPath, readOnly); err != nil {
+		return nil, err
 	}
 
 	glog.V(4).Infof("s3: volume %s successfully mounted to %s", volumeID, targetPath)
@@ -143,7 +271,7 @@ func (d *Driver) NodeUnpublishVolume(
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
 
-	if err := mounter.Unmount(targetPath); err != nil {
+	if err := unpublishVolume(volumeID, targetPath); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	glog.V(4).Infof("s3: volume %s has been unmounted.", volumeID)
@@ -152,7 +280,7 @@ func (d *Driver) NodeUnpublishVolume(
 }
 
 func (d *Driver) NodeStageVolume(
-	_ context.Context, req *csi.NodeStageVolumeRequest,
+	ctx context.Context, req *csi.NodeStageVolumeRequest,
 ) (*csi.NodeStageVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	stagingTargetPath := req.GetStagingTargetPath()
@@ -178,19 +306,36 @@ func (d *Driver) NodeStageVolume(
 	if !notMnt {
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	client, err := d.clients.ClientFromSecret(req.GetSecrets())
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
 	}
 
-	meta := getMeta(bucketName, prefix, req.VolumeContext)
-	mntr, err := mounter.New(meta, client.Config)
+	volumeCtx, err := volumeContext(ctx, client, bucketName, prefix, req.GetVolumeContext())
 	if err != nil {
 		return nil, err
 	}
-	if err := mntr.Mount(stagingTargetPath, volumeID); err != nil {
+	mode := req.GetVolumeCapability().GetAccessMode().GetMode()
+	// Anonymous volumes are read-only and can't be locked
+	readOnly := readOnlyMode(mode) || client.Config.Anonymous
+	if singleNodeMode(mode) && !client.Config.Anonymous {
+		if err := d.lockVolume(ctx, client, volumeID); err != nil {
+			return nil, err
+		}
+	}
+	if podCredentialsEnabled(volumeCtx) {
+		// Every pod mounts the volume itself in NodePublishVolume
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+	meta := getMeta(bucketName, prefix, volumeCtx)
+	if err := stageVolume(meta, client.Config, volumeID, stagingTargetPath, readOnly); err != nil {
+		d.unlockVolume(volumeID)
 		return nil, err
 	}
+	if enforceCapacityEnabled(volumeCtx) && !readOnly {
+		d.watchCapacity(client, volumeID, stagingTargetPath)
+	}
+	d.watchWebIdentityToken(client.Config, volumeID)
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -209,49 +354,244 @@ func (d *Driver) NodeUnstageVolume(
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
 
+	d.unwatchCapacity(volumeID)
+	d.unwatchWebIdentityToken(volumeID)
+	if err := unstageVolume(volumeID, stagingTargetPath); err != nil {
+		return nil, err
+	}
+	// Unlock only once the mounter has written back everything
+	d.unlockVolume(volumeID)
+	glog.V(4).Infof("s3: volume %s has been unmounted from stage path %v.", volumeID, stagingTargetPath)
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// stageVolume mounts a volume at its staging path, read-only if requested
+func stageVolume(meta *s3.FSMeta, cfg *s3.Config, volumeID, stagingTargetPath string, readOnly bool) error {
+	mntr, err := mounter.New(meta, cfg)
+	if err != nil {
+		return err
+	}
+	if err := mntr.Mount(stagingTargetPath, volumeID); err != nil {
+		return err
+	}
+	if readOnly {
+		if err := mounter.SetReadOnly(stagingTargetPath, true); err != nil {
+			_ = unstageVolume(volumeID, stagingTargetPath)
+			return fmt.Errorf("failed to remount volume %s read-only: %v", volumeID, err)
+		}
+	}
+	return nil
+}
+
+// unstageVolume stops the mounter of a volume, wherever it runs
+func unstageVolume(volumeID, stagingTargetPath string) error {
 	proc, err := mounter.FindFuseMountProcess(stagingTargetPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	exists := false
 	if proc == nil {
 		exists, err = mounter.SystemdUnmount(volumeID)
 		if exists && err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if !exists {
 		_ = mounter.FuseUnmount(stagingTargetPath)
 	}
-	glog.V(4).Infof("s3: volume %s has been unmounted from stage path %v.", volumeID, stagingTargetPath)
-
-	return &csi.NodeUnstageVolumeResponse{}, nil
+	if err := mounter.RemoveWebIdentityToken(volumeID); err != nil {
+		glog.Warningf("failed to remove web identity token of volume %s: %v", volumeID, err)
+	}
+	return nil
 }
 
 // NodeGetCapabilities returns the supported capabilities of the node server
 func (d *Driver) NodeGetCapabilities(
 	_ context.Context, _ *csi.NodeGetCapabilitiesRequest,
 ) (*csi.NodeGetCapabilitiesResponse, error) {
-	// currently there is a single NodeServer capability according to the spec
-	nscap := &csi.NodeServiceCapability{
-		Type: &csi.NodeServiceCapability_Rpc{
-			Rpc: &csi.NodeServiceCapability_RPC{
-				Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+	var nscaps []*csi.NodeServiceCapability //nolint:prealloc
+	for _, c := range []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+	} {
+		nscaps = append(nscaps, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: c,
+				},
 			},
-		},
+		})
 	}
 
 	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{
-			nscap,
-		},
+		Capabilities: nscaps,
 	}, nil
 }
 
+// NodeExpandVolume makes rclone and s3fs show the new capacity of a volume as
+// the size of its filesystem, which they only learn when they are started.
+// The volume is staged again if no pod uses it. Otherwise that would break
+// the pods, so the expansion fails with FailedPrecondition and stays pending
+// until the volume is staged again anyway.
 func (d *Driver) NodeExpandVolume(
-	_ context.Context, _ *csi.NodeExpandVolumeRequest,
+	ctx context.Context, req *csi.NodeExpandVolumeRequest,
 ) (*csi.NodeExpandVolumeResponse, error) {
-	return &csi.NodeExpandVolumeResponse{}, status.Error(codes.Unimplemented, "NodeExpandVolume is not implemented")
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+	stagingTargetPath := req.GetStagingTargetPath()
+	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
+
+	// Check arguments
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+	if _, err := os.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("volume path %s does not exist", volumePath))
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(volumePath, &st); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to statfs %s: %v", volumePath, err))
+	}
+	// The size is rounded down to whole blocks
+	size := int64(st.Blocks) * int64(st.Bsize)
+	if size+int64(st.Bsize) > capacityBytes {
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: capacityBytes}, nil
+	}
+	staged := false
+	if len(stagingTargetPath) > 0 {
+		notMnt, err := mount.New("").IsLikelyNotMountPoint(stagingTargetPath)
+		staged = err == nil && !notMnt
+	}
+	if !staged {
+		// Volumes mounted by every pod have nothing at the staging path
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf(
+			"volume %s is mounted by its pod, it shows the new capacity once it's mounted again", volumeID))
+	}
+	refs, err := mount.New("").GetMountRefs(stagingTargetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to find mounts of %s: %v", stagingTargetPath, err))
+	}
+	if len(refs) > 0 {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf(
+			"volume %s is in use, it shows the new capacity once it's staged again", volumeID))
+	}
+
+	client, err := d.clients.ClientFromSecret(d.secretsOr(req.GetSecrets()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %s", err))
+	}
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	volumeCtx, err := volumeContext(ctx, client, bucketName, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	readOnly := readOnlyMode(req.GetVolumeCapability().GetAccessMode().GetMode()) || client.Config.Anonymous
+	glog.V(4).Infof("Staging volume %s again to expand it to %d bytes", volumeID, capacityBytes)
+	if err := unstageVolume(volumeID, stagingTargetPath); err != nil {
+		return nil, err
+	}
+	if err := stageVolume(getMeta(bucketName, prefix, volumeCtx), client.Config, volumeID, stagingTargetPath, readOnly); err != nil {
+		return nil, err
+	}
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: capacityBytes}, nil
+}
+
+// NodeGetVolumeStats reports the usage of the FUSE filesystem. The numbers are
+// whatever the mounter makes up, S3 itself has no notion of free space.
+func (d *Driver) NodeGetVolumeStats(
+	_ context.Context, req *csi.NodeGetVolumeStatsRequest,
+) (*csi.NodeGetVolumeStatsResponse, error) {
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+
+	// Check arguments
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+
+	// Prefer the FUSE mount itself over its bind mount
+	statPath := req.GetStagingTargetPath()
+	if len(statPath) == 0 {
+		statPath = volumePath
+	}
+	if _, err := os.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("volume path %s does not exist", volumePath))
+		}
+		if mount.IsCorruptedMnt(err) {
+			return abnormalVolumeStats(fmt.Sprintf("volume path %s is not accessible: %v", volumePath, err)), nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	notMnt, err := mount.New("").IsLikelyNotMountPoint(statPath)
+	if err == nil && notMnt && statPath != volumePath {
+		// Volumes mounted by every pod have nothing at the staging path
+		statPath = volumePath
+		notMnt, err = mount.New("").IsLikelyNotMountPoint(statPath)
+	}
+	if err != nil {
+		if mount.IsCorruptedMnt(err) {
+			return abnormalVolumeStats(fmt.Sprintf("mount %s is not accessible: %v", statPath, err)), nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if notMnt {
+		return abnormalVolumeStats(fmt.Sprintf("volume %s is not mounted at %s", volumeID, statPath)), nil
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(statPath, &st); err != nil {
+		if mount.IsCorruptedMnt(err) {
+			return abnormalVolumeStats(fmt.Sprintf("mount %s is not accessible: %v", statPath, err)), nil
+		}
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to statfs %s: %v", statPath, err))
+	}
+	blockSize := int64(st.Bsize)
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     int64(st.Blocks) * blockSize,
+				Available: int64(st.Bavail) * blockSize,
+				Used:      int64(st.Blocks-st.Bfree) * blockSize,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     int64(st.Files),
+				Available: int64(st.Ffree),
+				Used:      int64(st.Files - st.Ffree),
+			},
+		},
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  "volume is mounted",
+		},
+	}, nil
+}
+
+func abnormalVolumeStats(message string) *csi.NodeGetVolumeStatsResponse {
+	glog.Warningf("s3: %s", message)
+	return &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  message,
+		},
+	}
 }
 
 func checkMount(targetPath string) (bool, error) {
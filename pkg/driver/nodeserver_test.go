@@ -0,0 +1,145 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeMeta returns the same metadata for every volume
+type fakeMeta struct {
+	meta *s3.FSMeta
+	err  error
+}
+
+func (f *fakeMeta) GetFSMeta(_ context.Context, _, _ string) (*s3.FSMeta, error) {
+	return f.meta, f.err
+}
+
+var _ = DescribeTable("volumeContext",
+	func(meta *s3.FSMeta, metaErr error, reqCtx, volumeCtx map[string]string) {
+		ctx, err := volumeContext(context.Background(), &fakeMeta{meta: meta, err: metaErr}, "bucket", "pvc", reqCtx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ctx).To(Equal(volumeCtx))
+	},
+	Entry("request without metadata", nil, nil,
+		map[string]string{"mounter": "rclone"}, map[string]string{"mounter": "rclone"}),
+	Entry("no context at all", nil, nil, nil, nil),
+	Entry("request with metadata",
+		&s3.FSMeta{CapacityBytes: 10, Parameters: map[string]string{"mounter": "s3fs"}}, nil,
+		map[string]string{"mounter": "rclone"}, map[string]string{"mounter": "rclone", "capacity": "10"}),
+	Entry("capacity of the metadata after an expansion",
+		&s3.FSMeta{CapacityBytes: 20}, nil,
+		map[string]string{"capacity": "10"}, map[string]string{"capacity": "20"}),
+	Entry("static volume without attributes",
+		&s3.FSMeta{CapacityBytes: 10, Parameters: map[string]string{"mounter": "s3fs"}}, nil,
+		nil, map[string]string{"mounter": "s3fs", "capacity": "10"}),
+	Entry("enforceCapacity of the metadata",
+		&s3.FSMeta{Parameters: map[string]string{"enforceCapacity": "true"}}, nil,
+		map[string]string{"mounter": "rclone"}, map[string]string{"mounter": "rclone", "enforceCapacity": "true"}),
+	Entry("unreadable metadata",
+		nil, errors.New("unavailable"),
+		map[string]string{"mounter": "rclone"}, map[string]string{"mounter": "rclone"}),
+)
+
+var _ = Describe("volumeContext", func() {
+	It("fails without metadata or a context to fall back to", func() {
+		_, err := volumeContext(context.Background(), &fakeMeta{err: errors.New("unavailable")}, "bucket", "pvc", nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = DescribeTable("bindOptions",
+	func(mountFlags []string, readOnly bool, options []string) {
+		Expect(bindOptions(mountFlags, readOnly)).To(Equal(options))
+	},
+	Entry("none", nil, false, []string{"bind"}),
+	Entry("read-only", nil, true, []string{"bind", "ro"}),
+	Entry("mount flags", []string{"noexec", "nosuid"}, false, []string{"bind", "noexec", "nosuid"}),
+	Entry("read-only with mount flags", []string{"noexec"}, true, []string{"bind", "noexec", "ro"}),
+)
+
+var _ = Describe("NodeGetVolumeStats", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "csi-stats")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reports a volume which isn't mounted as abnormal", func() {
+		resp, err := (&Driver{}).NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "bucket/pvc",
+			VolumePath: dir,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetVolumeCondition().GetAbnormal()).To(BeTrue())
+		Expect(resp.GetUsage()).To(BeEmpty())
+	})
+
+	It("fails for a missing volume path", func() {
+		_, err := (&Driver{}).NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "bucket/pvc",
+			VolumePath: filepath.Join(dir, "missing"),
+		})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+
+	It("fails without a volume", func() {
+		_, err := (&Driver{}).NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumePath: dir})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+})
+
+var _ = Describe("NodeExpandVolume", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "csi-expand")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	expand := func(volumePath, stagingTargetPath string, capacity int64) (*csi.NodeExpandVolumeResponse, error) {
+		return (&Driver{}).NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+			VolumeId:          "bucket/pvc",
+			VolumePath:        volumePath,
+			StagingTargetPath: stagingTargetPath,
+			CapacityRange:     &csi.CapacityRange{RequiredBytes: capacity},
+		})
+	}
+
+	It("succeeds once the filesystem shows the capacity", func() {
+		resp, err := expand(dir, dir, 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetCapacityBytes()).To(BeEquivalentTo(1 << 20))
+	})
+
+	It("stays pending while the volume isn't staged", func() {
+		_, err := expand(dir, filepath.Join(dir, "staging"), 1<<62)
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+	})
+
+	It("fails for a missing volume path", func() {
+		_, err := expand(filepath.Join(dir, "missing"), dir, 1<<20)
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+})
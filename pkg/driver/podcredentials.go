@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/mounter"
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+const (
+	// podCredentialsKey makes every pod mount a volume with its own
+	// credentials, exchanged for its service account token, instead of
+	// sharing the staged mount
+	podCredentialsKey = "podCredentials"
+	// podCredentialsAudienceKey picks the token to use when the CSIDriver
+	// requests tokens for several audiences
+	podCredentialsAudienceKey = "podCredentialsAudience"
+
+	// serviceAccountTokensKey holds the tokens requested by the tokenRequests
+	// of the CSIDriver
+	serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+)
+
+type serviceAccountToken struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp"`
+}
+
+func podCredentialsEnabled(volumeCtx map[string]string) bool {
+	enabled, _ := strconv.ParseBool(volumeCtx[podCredentialsKey])
+	return enabled
+}
+
+// podToken returns the service account token of the pod for the audience of
+// the volume, or the only one if the volume has no audience
+func podToken(volumeCtx map[string]string) (string, error) {
+	tokensJSON := volumeCtx[serviceAccountTokensKey]
+	if tokensJSON == "" {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf(
+			"volume uses %s but the request has no service account token, check tokenRequests of the CSIDriver",
+			podCredentialsKey))
+	}
+	tokens := map[string]serviceAccountToken{}
+	if err := json.Unmarshal([]byte(tokensJSON), &tokens); err != nil {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("failed to parse service account tokens: %v", err))
+	}
+	audience := volumeCtx[podCredentialsAudienceKey]
+	if audience == "" {
+		if len(tokens) != 1 {
+			return "", status.Error(codes.InvalidArgument, fmt.Sprintf(
+				"got service account tokens for %d audiences, set %s to pick one", len(tokens), podCredentialsAudienceKey))
+		}
+		for _, token := range tokens {
+			return token.Token, nil
+		}
+	}
+	token, ok := tokens[audience]
+	if !ok {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("no service account token for audience %s", audience))
+	}
+	return token.Token, nil
+}
+
+// podConfig returns the configuration of a pod's mount. It keeps everything
+// but the keys of the secret, the role of the secret is assumed with the
+// pod's token.
+func podConfig(cfg *s3.Config, token string) *s3.Config {
+	podCfg := *cfg
+	podCfg.AccessKeyID = ""
+	podCfg.SecretAccessKey = ""
+	podCfg.SessionToken = ""
+	podCfg.ExternalID = ""
+	podCfg.WebIdentityTokenFile = ""
+	podCfg.WebIdentityToken = token
+	return &podCfg
+}
+
+// podMountID identifies the mount of a pod, like the volume ID does for the
+// staged mount. NodeUnpublishVolume only has the target path to find it.
+func podMountID(volumeID, targetPath string) string {
+	sum := sha256.Sum256([]byte(targetPath))
+	return fmt.Sprintf("%s-%x", volumeID, sum[:8])
+}
+
+// publishPodVolume mounts a volume at the target path of a pod with the pod's
+// own credentials
+func publishPodVolume(meta *s3.FSMeta, cfg *s3.Config, volumeID, targetPath string, readOnly bool) error {
+	glog.V(3).Infof("Mounting volume %v at %v with the credentials of the pod", volumeID, targetPath)
+	if err := stageVolume(meta, cfg, podMountID(volumeID, targetPath), targetPath, readOnly); err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("failed to mount volume %s: %v", volumeID, err))
+	}
+	return nil
+}
+
+// podMounted tells whether the target path is a mount of its own and not a
+// bind mount of the staging path. A bind mount shares the device of the FUSE
+// mount it comes from, each FUSE mount gets a new one.
+func podMounted(targetPath string) (bool, error) {
+	infos, err := mount.ParseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	targetPath = filepath.Clean(targetPath)
+	var target *mount.MountInfo
+	for i := range infos {
+		if infos[i].MountPoint == targetPath {
+			target = &infos[i]
+		}
+	}
+	if target == nil || !strings.HasPrefix(target.FsType, "fuse") {
+		return false, nil
+	}
+	for _, info := range infos {
+		if info.ID != target.ID && info.Major == target.Major && info.Minor == target.Minor {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// unpublishVolume unmounts the target path of a pod, stopping the mounter if
+// the pod had its own
+func unpublishVolume(volumeID, targetPath string) error {
+	own, err := podMounted(targetPath)
+	if err != nil {
+		glog.Warningf("failed to check the mount of volume %s at %s: %v", volumeID, targetPath, err)
+	}
+	if own {
+		if err := unstageVolume(podMountID(volumeID, targetPath), targetPath); err != nil {
+			return err
+		}
+		if notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath); err != nil || notMnt {
+			return nil
+		}
+	}
+	return mounter.Unmount(targetPath)
+}
+
+// loggableContext returns a volume context without the service account
+// tokens, for logs
+func loggableContext(volumeCtx map[string]string) map[string]string {
+	if _, ok := volumeCtx[serviceAccountTokensKey]; !ok {
+		return volumeCtx
+	}
+	logged := make(map[string]string, len(volumeCtx))
+	for k, v := range volumeCtx {
+		logged[k] = v
+	}
+	logged[serviceAccountTokensKey] = "***"
+	return logged
+}
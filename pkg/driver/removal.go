@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// removalWait is how long a request waits for its removal to finish before
+// it fails and has to be retried
+const removalWait = 5 * time.Second
+
+// removal removes a volume or snapshot in the background. It outlives the
+// request which started it, err is set when done is closed.
+type removal struct {
+	done    chan struct{}
+	err     error
+	removed atomic.Int64
+}
+
+// removeInBackground starts remove under id unless it's running already and
+// waits for it. Removing a large volume takes longer than a request, so the
+// request fails with Aborted until the removal is done and the sidecar
+// retries it. The removal saves its progress, it resumes where it stopped if
+// the driver is restarted meanwhile.
+func (d *Driver) removeInBackground(ctx context.Context, id string, remove func(ctx context.Context, opts s3.RemoveOptions) error) error {
+	d.removalMu.Lock()
+	r, ok := d.removals[id]
+	if !ok {
+		if d.removals == nil {
+			d.removals = make(map[string]*removal)
+		}
+		r = &removal{done: make(chan struct{})}
+		d.removals[id] = r
+		opts := d.removeOpts
+		opts.Progress = r.removed.Store
+		go func() {
+			defer close(r.done)
+			r.err = remove(context.Background(), opts)
+		}()
+	}
+	d.removalMu.Unlock()
+
+	timer := time.NewTimer(removalWait)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		d.removalMu.Lock()
+		if d.removals[id] == r {
+			delete(d.removals, id)
+		}
+		d.removalMu.Unlock()
+		return r.err
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return status.Error(codes.Aborted, fmt.Sprintf("%s is being removed, %d objects removed so far", id, r.removed.Load()))
+}
+
+// removing tells whether id is being removed in the background
+func (d *Driver) removing(id string) bool {
+	d.removalMu.Lock()
+	defer d.removalMu.Unlock()
+	_, ok := d.removals[id]
+	return ok
+}
@@ -0,0 +1,45 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// sweepTrash periodically purges volumes which were moved to the trash
+// longer than trashTTL ago, until ctx is cancelled. It uses the driver's own
+// secret.
+func (d *Driver) sweepTrash(ctx context.Context) {
+	interval := min(d.trashTTL, time.Hour)
+	glog.Infof("Purging trashed volumes after %v, checking every %v", d.trashTTL, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.purgeTrash(ctx, time.Now().Add(-d.trashTTL)); err != nil && ctx.Err() == nil {
+			glog.Errorf("Failed to purge trash: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Driver) purgeTrash(ctx context.Context, before time.Time) error {
+	client, err := d.clients.ClientFromSecret(d.secrets)
+	if err != nil {
+		return err
+	}
+	buckets, err := client.ListBuckets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, bucketName := range buckets {
+		if err := client.PurgeTrash(ctx, bucketName, before, d.removeOpts); err != nil {
+			glog.Errorf("Failed to purge trash of bucket %s: %v", bucketName, err)
+		}
+	}
+	return nil
+}
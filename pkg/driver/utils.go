@@ -18,6 +18,7 @@ package driver
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -46,3 +47,23 @@ func NewControllerServiceCapability(cap csi.ControllerServiceCapability_RPC_Type
 		},
 	}
 }
+
+// paginate returns the [start, end) range of total entries to return for a
+// starting token and an entry limit, along with the token of the next page
+func paginate(total int, startingToken string, maxEntries int32) (int, int, string, error) {
+	start := 0
+	if startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", fmt.Errorf("invalid starting token %q", startingToken)
+		}
+	}
+	end := total
+	nextToken := ""
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		end = start + int(maxEntries)
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
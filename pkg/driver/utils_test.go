@@ -0,0 +1,36 @@
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("paginate",
+	func(total int, token string, maxEntries int32, start, end int, nextToken string) {
+		s, e, next, err := paginate(total, token, maxEntries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect([]int{s, e}).To(Equal([]int{start, end}))
+		Expect(next).To(Equal(nextToken))
+	},
+	Entry("everything", 5, "", int32(0), 0, 5, ""),
+	Entry("first page", 5, "", int32(2), 0, 2, "2"),
+	Entry("middle page", 5, "2", int32(2), 2, 4, "4"),
+	Entry("last page", 5, "4", int32(2), 4, 5, ""),
+	Entry("exact last page", 4, "2", int32(2), 2, 4, ""),
+	Entry("rest", 5, "3", int32(0), 3, 5, ""),
+	Entry("empty", 0, "", int32(2), 0, 0, ""),
+	Entry("after the end", 5, "5", int32(2), 5, 5, ""),
+	Entry("largest limit", 5, "1", int32(2147483647), 1, 5, ""),
+)
+
+var _ = DescribeTable("paginate with an invalid token",
+	func(token string) {
+		_, _, _, err := paginate(5, token, 2)
+		Expect(err).To(HaveOccurred())
+	},
+	Entry("not a number", "abc"),
+	Entry("negative", "-1"),
+	Entry("past the end", "6"),
+	Entry("overflowing", "99999999999999999999"),
+	Entry("fraction", "1.5"),
+)
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/mounter"
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/golang/glog"
+)
+
+// tokenCopyInterval is how often the web identity token of a staged volume is
+// copied for its mounter. Kubelet rotates projected tokens well before they
+// expire, at 80% of their lifetime.
+const tokenCopyInterval = time.Minute
+
+// webIdentityFromFile tells whether the secret assumes its role with a web
+// identity token read from a file, which is rotated
+func webIdentityFromFile(secrets map[string]string) bool {
+	cfg, err := s3.ConfigFromSecret(secrets)
+	if err != nil {
+		return false
+	}
+	id, err := cfg.WebIdentity()
+	return err == nil && id != nil && id.TokenFile != ""
+}
+
+// watchWebIdentityToken keeps the copy of the web identity token file of cfg
+// current for the mounter of a staged volume, which may run on the host where
+// the file doesn't exist
+func (d *Driver) watchWebIdentityToken(cfg *s3.Config, volumeID string) {
+	id, err := cfg.WebIdentity()
+	if err != nil || id == nil || id.TokenFile == "" {
+		return
+	}
+	d.startWatch("token/"+volumeID, func(ctx context.Context) {
+		ticker := time.NewTicker(tokenCopyInterval)
+		defer ticker.Stop()
+		for {
+			if err := mounter.UpdateWebIdentityToken(cfg, volumeID); err != nil {
+				glog.Errorf("failed to update web identity token of volume %s: %v", volumeID, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (d *Driver) unwatchWebIdentityToken(volumeID string) {
+	d.stopWatch("token/" + volumeID)
+}
@@ -0,0 +1,173 @@
+package mounter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+)
+
+// credentialsDir is where the shared configuration of the AWS SDK of mounters
+// is written. Like tlsDir, it is in the plugin directory shared with the host.
+const credentialsDir = "/csi/aws"
+
+// tokenOwner owns web identity tokens, as GeeseFS and TigrisFS read them
+// again after dropping root privileges to nobody
+const tokenOwner = 65534
+
+// Profiles of the shared configuration: awsProfile assumes the role with the
+// keys of awsSourceProfile
+const (
+	awsProfile       = "csi-s3"
+	awsSourceProfile = "csi-s3-source"
+)
+
+// credentialFiles are the paths of the shared configuration or the web
+// identity token of a mounter, empty if its credentials are passed in its
+// environment
+type credentialFiles struct {
+	Config      string
+	Credentials string
+	// RoleARN is assumed with Token
+	RoleARN string
+	Token   string
+	// STSEndpoint is where the mounter assumes its role
+	STSEndpoint string
+}
+
+// writeCredentialFiles writes a shared configuration assuming the role of
+// cfg, or its web identity token, so the AWS SDK of the mounter assumes the
+// role again before its credentials expire. The shared configuration is
+// named after its content, like the TLS files, the token after mountID.
+func writeCredentialFiles(cfg *s3.Config, mountID string) (*credentialFiles, error) {
+	id, err := cfg.WebIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if id != nil {
+		token, err := writeWebIdentityToken(id, mountID)
+		if err != nil {
+			return nil, err
+		}
+		return &credentialFiles{RoleARN: id.RoleARN, Token: token, STSEndpoint: id.STSEndpoint}, nil
+	}
+	role, err := cfg.AssumedRole()
+	if err != nil || role == nil {
+		return &credentialFiles{}, err
+	}
+	var config, creds strings.Builder
+	fmt.Fprintf(&creds, "[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\n",
+		awsSourceProfile, role.AccessKeyID, role.SecretAccessKey)
+	if role.SessionToken != "" {
+		fmt.Fprintf(&creds, "aws_session_token = %s\n", role.SessionToken)
+	}
+	fmt.Fprintf(&config, "[profile %s]\nrole_arn = %s\nsource_profile = %s\n", awsProfile, role.RoleARN, awsSourceProfile)
+	for _, v := range []struct{ key, value string }{
+		{"role_session_name", role.SessionName},
+		{"external_id", role.ExternalID},
+		{"region", cfg.Region},
+	} {
+		if v.value != "" {
+			fmt.Fprintf(&config, "%s = %s\n", v.key, v.value)
+		}
+	}
+	if role.DurationSeconds > 0 {
+		fmt.Fprintf(&config, "duration_seconds = %d\n", role.DurationSeconds)
+	}
+	files := &credentialFiles{STSEndpoint: role.STSEndpoint}
+	for _, f := range []struct {
+		content string
+		path    *string
+	}{
+		{config.String(), &files.Config},
+		{creds.String(), &files.Credentials},
+	} {
+		if err := os.MkdirAll(credentialsDir, 0711); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", credentialsDir, err)
+		}
+		path := filepath.Join(credentialsDir, fmt.Sprintf("%x.ini", sha256.Sum256([]byte(f.content))))
+		if err := os.WriteFile(path, []byte(f.content), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		*f.path = path
+	}
+	return files, nil
+}
+
+// env returns the environment variables pointing the AWS SDK to the files,
+// as found in dir
+func (f *credentialFiles) env(dir string) []string {
+	if f.Token != "" {
+		return []string{
+			"AWS_ROLE_ARN=" + f.RoleARN,
+			"AWS_WEB_IDENTITY_TOKEN_FILE=" + filepath.Join(dir, filepath.Base(f.Token)),
+			"AWS_ENDPOINT_URL_STS=" + f.STSEndpoint,
+		}
+	}
+	if f.Config == "" {
+		return nil
+	}
+	return []string{
+		"AWS_CONFIG_FILE=" + filepath.Join(dir, filepath.Base(f.Config)),
+		"AWS_SHARED_CREDENTIALS_FILE=" + filepath.Join(dir, filepath.Base(f.Credentials)),
+		"AWS_PROFILE=" + awsProfile,
+		// Version 1 of the SDK ignores roles of the config file without it
+		"AWS_SDK_LOAD_CONFIG=1",
+		"AWS_ENDPOINT_URL_STS=" + f.STSEndpoint,
+	}
+}
+
+// tokenPath returns the path of the web identity token of the mounter of
+// mountID
+func tokenPath(mountID string) string {
+	sum := sha256.Sum256([]byte(mountID))
+	return filepath.Join(credentialsDir, fmt.Sprintf("%x.token", sum[:8]))
+}
+
+// writeWebIdentityToken writes the current token of id for the mounter of
+// mountID. It is replaced at once, the mounter may read it at any time.
+func writeWebIdentityToken(id *s3.WebIdentity, mountID string) (string, error) {
+	token, err := id.ReadToken()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(credentialsDir, 0711); err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", credentialsDir, err)
+	}
+	path := tokenPath(mountID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token), 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := os.Chown(tmp, tokenOwner, tokenOwner); err != nil {
+		return "", fmt.Errorf("failed to change owner of %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return path, nil
+}
+
+// UpdateWebIdentityToken writes the current web identity token of cfg again
+// for the mounter of mountID, which reads it whenever it assumes its role. It
+// does nothing if cfg has no web identity.
+func UpdateWebIdentityToken(cfg *s3.Config, mountID string) error {
+	id, err := cfg.WebIdentity()
+	if err != nil || id == nil {
+		return err
+	}
+	_, err = writeWebIdentityToken(id, mountID)
+	return err
+}
+
+// RemoveWebIdentityToken removes the web identity token of the mounter of
+// mountID, if it has one
+func RemoveWebIdentityToken(mountID string) error {
+	if err := os.Remove(tokenPath(mountID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
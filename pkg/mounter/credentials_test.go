@@ -0,0 +1,45 @@
+package mounter
+
+import (
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("credentialFiles.env",
+	func(files *credentialFiles, envs []string) {
+		Expect(files.env("/plugin/aws")).To(Equal(envs))
+	},
+	Entry("credentials in the environment", &credentialFiles{}, nil),
+	Entry("assumed role", &credentialFiles{
+		Config:      "/csi/aws/config.ini",
+		Credentials: "/csi/aws/credentials.ini",
+		STSEndpoint: "https://sts.example.com",
+	}, []string{
+		"AWS_CONFIG_FILE=/plugin/aws/config.ini",
+		"AWS_SHARED_CREDENTIALS_FILE=/plugin/aws/credentials.ini",
+		"AWS_PROFILE=csi-s3",
+		"AWS_SDK_LOAD_CONFIG=1",
+		"AWS_ENDPOINT_URL_STS=https://sts.example.com",
+	}),
+	Entry("web identity", &credentialFiles{
+		RoleARN:     "arn:aws:iam::123456789012:role/csi",
+		Token:       "/csi/aws/0123456789abcdef.token",
+		STSEndpoint: "https://sts.example.com",
+	}, []string{
+		"AWS_ROLE_ARN=arn:aws:iam::123456789012:role/csi",
+		"AWS_WEB_IDENTITY_TOKEN_FILE=/plugin/aws/0123456789abcdef.token",
+		"AWS_ENDPOINT_URL_STS=https://sts.example.com",
+	}),
+)
+
+var _ = DescribeTable("awsEnv",
+	func(creds credentials.Value, envs []string) {
+		Expect(awsEnv(creds)).To(Equal(envs))
+	},
+	Entry("anonymous", credentials.Value{}, nil),
+	Entry("keys", credentials.Value{AccessKeyID: "key", SecretAccessKey: "secret"},
+		[]string{"AWS_ACCESS_KEY_ID=key", "AWS_SECRET_ACCESS_KEY=secret"}),
+	Entry("session", credentials.Value{AccessKeyID: "key", SecretAccessKey: "secret", SessionToken: "token"},
+		[]string{"AWS_ACCESS_KEY_ID=key", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token"}),
+)
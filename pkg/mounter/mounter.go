@@ -7,6 +7,7 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
 	systemd "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/golang/glog"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/mitchellh/go-ps"
 	"k8s.io/mount-utils"
 )
@@ -32,6 +34,10 @@ const (
 	TypeKey             = "mounter"
 	BucketKey           = "bucket"
 	OptionsKey          = "options"
+	// CryptKey encrypts files on the client with an rclone crypt remote,
+	// CryptFilenameEncryptionKey selects how their names are encrypted
+	CryptKey                   = "crypt"
+	CryptFilenameEncryptionKey = "cryptFilenameEncryption"
 )
 
 // New returns a new mounter depending on the mounterType parameter.
@@ -43,6 +49,13 @@ func New(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	if len(meta.Mounter) == 0 {
 		mounter = cfg.Mounter
 	}
+	// Never write plain files to a volume meant to be encrypted
+	if err := CheckCrypt(meta.Parameters, mounter); err != nil {
+		return nil, err
+	}
+	if err := CheckEncryption(meta.Parameters, mounter); err != nil {
+		return nil, err
+	}
 	switch mounter {
 	case geesefsMounterType:
 		return newTigrisFSMounter(meta, cfg, geesefsMounterType)
@@ -62,21 +75,131 @@ func New(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	}
 }
 
+// ShowsCapacity tells whether mounterType shows the capacity of a volume as
+// the size of its filesystem, which it only learns when it's started
+func ShowsCapacity(mounterType string) bool {
+	return mounterType == rcloneMounterType || mounterType == s3fsMounterType
+}
+
+// CryptEnabled tells whether the parameters of a volume encrypt its files
+// with rclone crypt
+func CryptEnabled(params map[string]string) bool {
+	enabled, _ := strconv.ParseBool(params[CryptKey])
+	return enabled
+}
+
+// CheckCrypt returns an error if the crypt parameters of a volume are invalid
+// or can't be used with mounterType
+func CheckCrypt(params map[string]string, mounterType string) error {
+	if !CryptEnabled(params) {
+		return nil
+	}
+	if mounterType != rcloneMounterType {
+		return fmt.Errorf("%s requires the %s mounter", CryptKey, rcloneMounterType)
+	}
+	switch mode := params[CryptFilenameEncryptionKey]; mode {
+	case "", "standard", "obfuscate", "off":
+		return nil
+	default:
+		return fmt.Errorf("%s must be standard, obfuscate or off, got %q", CryptFilenameEncryptionKey, mode)
+	}
+}
+
+// CheckEncryption returns an error if the server-side encryption of a volume
+// can't be used with mounterType. GeeseFS and TigrisFS only take the SSE-C key
+// on their command line, where anyone on the node could read it.
+func CheckEncryption(params map[string]string, mounterType string) error {
+	if params[s3.EncryptionKey] != s3.EncryptionSSEC {
+		return nil
+	}
+	switch mounterType {
+	case s3fsMounterType, rcloneMounterType:
+		return nil
+	default:
+		return fmt.Errorf("%s=%s requires the %s or %s mounter",
+			s3.EncryptionKey, s3.EncryptionSSEC, rcloneMounterType, s3fsMounterType)
+	}
+}
+
+// credentialEnv returns the environment variables passing the credentials of
+// cfg to a mounter using the AWS SDK, whichever source they come from. Roles
+// are assumed by the mounter itself, with the files of writeCredentialFiles,
+// to renew their credentials. Other credentials are passed resolved. The
+// proxies of cfg are passed along, as the mounter doesn't inherit the
+// environment of the driver when it runs as a systemd unit.
+func credentialEnv(cfg *s3.Config) ([]string, error) {
+	assumed, err := assumesRole(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if assumed {
+		return cfg.Proxy.Env(), nil
+	}
+	creds, err := cfg.Credentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %v", err)
+	}
+	return append(awsEnv(creds), cfg.Proxy.Env()...), nil
+}
+
+// assumesRole tells whether cfg assumes a role, with keys or a web identity
+func assumesRole(cfg *s3.Config) (bool, error) {
+	role, err := cfg.AssumedRole()
+	if err != nil || role != nil {
+		return role != nil, err
+	}
+	id, err := cfg.WebIdentity()
+	return id != nil, err
+}
+
+// awsEnv returns the environment variables of the AWS SDK for creds, none for
+// anonymous access
+func awsEnv(creds credentials.Value) []string {
+	if creds.AccessKeyID == "" {
+		return nil
+	}
+	envs := []string{
+		"AWS_ACCESS_KEY_ID=" + creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + creds.SecretAccessKey,
+	}
+	if creds.SessionToken != "" {
+		envs = append(envs, "AWS_SESSION_TOKEN="+creds.SessionToken)
+	}
+	return envs
+}
+
 func fuseMount(path string, command string, args []string, envs []string) error {
 	cmd := exec.Command(command, args...)
 	cmd.Stderr = os.Stderr
 	// cmd.Environ() returns envs inherited from the current process
 	cmd.Env = append(cmd.Environ(), envs...)
-	glog.V(3).Infof("mounting fuse with command: %s and args: %s", command, args)
+	glog.V(3).Infof("mounting fuse with command: %s and args: %s", command, loggableArgs(args))
 
 	out, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("error fuseMount command: %s\nargs: %s\noutput: %s", command, args, out)
+		return fmt.Errorf("error fuseMount command: %s\nargs: %s\noutput: %s", command, loggableArgs(args), out)
 	}
 
 	return waitForMount(path, 10*time.Second)
 }
 
+// secretArgs are mounter arguments whose values must not be logged
+var secretArgs = []string{"--sse-c"}
+
+// loggableArgs returns the arguments of a mounter with secret values redacted
+func loggableArgs(args []string) []string {
+	logged := make([]string, len(args))
+	for i, arg := range args {
+		logged[i] = arg
+		for _, secret := range secretArgs {
+			if strings.HasPrefix(arg, secret+"=") {
+				logged[i] = secret + "=***"
+			}
+		}
+	}
+	return logged
+}
+
 func Unmount(path string) error {
 	if err := mount.New("").Unmount(path); err != nil {
 		return err
@@ -2,18 +2,20 @@ package mounter
 
 import (
 	"fmt"
+	"os/exec"
 	"path"
+	"strings"
 
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
 )
 
 // Implements Mounter
 type rcloneMounter struct {
-	meta            *s3.FSMeta
-	url             string
-	region          string
-	accessKeyID     string
-	secretAccessKey string
+	meta   *s3.FSMeta
+	url    string
+	region string
+	envs   []string
+	cfg    *s3.Config
 }
 
 const (
@@ -21,34 +23,142 @@ const (
 )
 
 func newRcloneMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
+	envs, err := credentialEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	url, err := cfg.EndpointURL()
+	if err != nil {
+		return nil, err
+	}
 	return &rcloneMounter{
-		meta:            meta,
-		url:             cfg.Endpoint,
-		region:          cfg.Region,
-		accessKeyID:     cfg.AccessKeyID,
-		secretAccessKey: cfg.SecretAccessKey,
+		meta:   meta,
+		url:    url,
+		region: cfg.Region,
+		envs:   envs,
+		cfg:    cfg,
 	}, nil
 }
 
 func (rclone *rcloneMounter) Mount(target, volumeID string) error {
+	pathStyle, err := rclone.cfg.PathStyle(rclone.meta.BucketName)
+	if err != nil {
+		return err
+	}
+	remote := fmt.Sprintf(":s3:%s", path.Join(rclone.meta.BucketName, rclone.meta.Prefix))
+	var cryptArgs, cryptEnvs []string
+	if CryptEnabled(rclone.meta.Parameters) {
+		var err error
+		if cryptArgs, cryptEnvs, err = rclone.crypt(remote); err != nil {
+			return err
+		}
+		remote = ":crypt:"
+	}
 	args := []string{
 		"mount",
-		fmt.Sprintf(":s3:%s", path.Join(rclone.meta.BucketName, rclone.meta.Prefix)),
+		remote,
 		target,
 		"--daemon",
 		"--s3-provider=AWS",
-		"--s3-env-auth=true",
+		// Without keys or env-auth rclone doesn't sign requests
+		fmt.Sprintf("--s3-env-auth=%t", !rclone.cfg.Anonymous),
 		fmt.Sprintf("--s3-endpoint=%s", rclone.url),
+		fmt.Sprintf("--s3-force-path-style=%t", pathStyle),
 		"--allow-other",
 		"--vfs-cache-mode=writes",
 	}
 	if rclone.region != "" {
 		args = append(args, fmt.Sprintf("--s3-region=%s", rclone.region))
 	}
-	args = append(args, rclone.meta.MountOptions...)
-	envs := []string{
-		"AWS_ACCESS_KEY_ID=" + rclone.accessKeyID,
-		"AWS_SECRET_ACCESS_KEY=" + rclone.secretAccessKey,
+	if rclone.meta.CapacityBytes > 0 {
+		// without a suffix the size would be in KiB
+		args = append(args, fmt.Sprintf("--vfs-disk-space-total-size=%dB", rclone.meta.CapacityBytes))
+	}
+	if rclone.cfg.Anonymous {
+		args = append(args, "--read-only")
+	}
+	if rclone.cfg.RequesterPays {
+		args = append(args, "--s3-requester-pays")
+	}
+	if rclone.cfg.Insecure {
+		args = append(args, "--no-check-certificate")
+	}
+	files, err := writeTLSFiles(rclone.cfg)
+	if err != nil {
+		return err
+	}
+	if files.CABundle != "" {
+		args = append(args, fmt.Sprintf("--ca-cert=%s", files.CABundle))
+	}
+	if files.ClientCert != "" {
+		args = append(args, fmt.Sprintf("--client-cert=%s", files.ClientCert), fmt.Sprintf("--client-key=%s", files.ClientKey))
+	}
+	creds, err := writeCredentialFiles(rclone.cfg, volumeID)
+	if err != nil {
+		return err
 	}
+	enc, err := s3.VolumeEncryption(rclone.meta.Parameters, rclone.cfg)
+	if err != nil {
+		return err
+	}
+	envs := append(creds.env(credentialsDir), rclone.envs...)
+	if enc != nil {
+		switch enc.Mode {
+		case s3.EncryptionSSES3:
+			args = append(args, "--s3-server-side-encryption=AES256")
+		case s3.EncryptionSSEKMS:
+			args = append(args, "--s3-server-side-encryption=aws:kms")
+			if enc.KMSKeyID != "" {
+				args = append(args, fmt.Sprintf("--s3-sse-kms-key-id=%s", enc.KMSKeyID))
+			}
+		case s3.EncryptionSSEC:
+			// The key is kept out of the command line
+			args = append(args, "--s3-sse-customer-algorithm=AES256")
+			envs = append(envs, "RCLONE_S3_SSE_CUSTOMER_KEY_BASE64="+enc.CustomerKeyBase64())
+		}
+	}
+	args = append(args, cryptArgs...)
+	envs = append(envs, cryptEnvs...)
+	args = append(args, rclone.meta.MountOptions...)
 	return fuseMount(target, rcloneCmd, args, envs)
 }
+
+// crypt returns the arguments and environment variables layering a crypt
+// remote over remote. The passwords of the secret are passed obscured, as
+// rclone expects them.
+func (rclone *rcloneMounter) crypt(remote string) ([]string, []string, error) {
+	if rclone.cfg.CryptPassword == "" {
+		return nil, nil, fmt.Errorf("%s requires cryptPassword in the secret", CryptKey)
+	}
+	args := []string{fmt.Sprintf("--crypt-remote=%s", remote)}
+	if mode := rclone.meta.Parameters[CryptFilenameEncryptionKey]; mode != "" {
+		args = append(args, fmt.Sprintf("--crypt-filename-encryption=%s", mode))
+	}
+	var envs []string
+	for _, p := range []struct{ env, password string }{
+		{"RCLONE_CRYPT_PASSWORD", rclone.cfg.CryptPassword},
+		{"RCLONE_CRYPT_PASSWORD2", rclone.cfg.CryptPassword2},
+	} {
+		if p.password == "" {
+			continue
+		}
+		obscured, err := rcloneObscure(p.password)
+		if err != nil {
+			return nil, nil, err
+		}
+		envs = append(envs, p.env+"="+obscured)
+	}
+	return args, envs, nil
+}
+
+// rcloneObscure obscures a password like rclone obscure, reading it from
+// stdin to keep it out of the command line
+func rcloneObscure(password string) (string, error) {
+	cmd := exec.Command(rcloneCmd, "obscure", "-")
+	cmd.Stdin = strings.NewReader(password)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to obscure crypt password: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
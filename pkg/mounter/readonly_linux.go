@@ -0,0 +1,23 @@
+package mounter
+
+import (
+	"syscall"
+)
+
+// SetReadOnly remounts the filesystem mounted at path read-only or back to
+// read-write. This applies to all bind mounts of it too.
+func SetReadOnly(path string, readOnly bool) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+	// A remount resets all mount flags, so keep the ones which are set.
+	// ST_* flags reported by statfs have the same values as MS_* ones.
+	flags := uintptr(st.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+		syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+	flags |= syscall.MS_REMOUNT
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	return syscall.Mount("", path, "", flags, "")
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package mounter
+
+import (
+	"errors"
+)
+
+// SetReadOnly is only supported on Linux
+func SetReadOnly(_ string, _ bool) error {
+	return errors.New("remounting read-only is not supported on this platform")
+}
@@ -1,10 +1,13 @@
 package mounter
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"time"
 
 	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/golang/glog"
 )
 
 // Implements Mounter
@@ -13,6 +16,11 @@ type s3fsMounter struct {
 	url           string
 	region        string
 	pwFileContent string
+	// envs pass temporary credentials, which the password file can't hold
+	envs []string
+	// expiration is when the credentials of an assumed role expire
+	expiration time.Time
+	cfg        *s3.Config
 }
 
 const (
@@ -20,31 +28,110 @@ const (
 )
 
 func newS3fsMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
-	return &s3fsMounter{
+	if cfg.TLSClientCert != "" {
+		return nil, fmt.Errorf("s3fs doesn't support client certificates")
+	}
+	// Roles are assumed here, s3fs gets the temporary credentials of the
+	// session as it can't assume them itself
+	creds, err := cfg.Credentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %v", err)
+	}
+	url, err := cfg.EndpointURL()
+	if err != nil {
+		return nil, err
+	}
+	mounter := &s3fsMounter{
 		meta:          meta,
-		url:           cfg.Endpoint,
+		url:           url,
 		region:        cfg.Region,
-		pwFileContent: cfg.AccessKeyID + ":" + cfg.SecretAccessKey,
-	}, nil
+		pwFileContent: creds.AccessKeyID + ":" + creds.SecretAccessKey,
+		expiration:    creds.Expiration,
+		cfg:           cfg,
+	}
+	if creds.SessionToken != "" {
+		// s3fs prefers these over the password file
+		mounter.envs = awsEnv(creds)
+	}
+	mounter.envs = append(mounter.envs, cfg.Proxy.Env()...)
+	return mounter, nil
 }
 
-func (s3fs *s3fsMounter) Mount(target, _ string) error {
-	if err := writes3fsPass(s3fs.pwFileContent); err != nil {
+// Mount starts s3fs. It never reads its credentials again, so those of an
+// assumed role last until its session expires, and the volume has to be
+// staged again before that.
+func (s3fs *s3fsMounter) Mount(target, volumeID string) error {
+	if !s3fs.expiration.IsZero() {
+		glog.Warningf("s3fs can't renew the credentials of volume %s, they expire at %s",
+			volumeID, s3fs.expiration.Format(time.RFC3339))
+	}
+	if !s3fs.cfg.Anonymous {
+		if err := writes3fsPass(s3fs.pwFileContent); err != nil {
+			return err
+		}
+	}
+	pathStyle, err := s3fs.cfg.PathStyle(s3fs.meta.BucketName)
+	if err != nil {
 		return err
 	}
 	args := []string{
 		fmt.Sprintf("%s:/%s", s3fs.meta.BucketName, s3fs.meta.Prefix),
 		target,
-		"-o", "use_path_request_style",
 		"-o", fmt.Sprintf("url=%s", s3fs.url),
 		"-o", "allow_other",
 		"-o", "mp_umask=000",
 	}
+	if pathStyle {
+		args = append(args, "-o", "use_path_request_style")
+	}
+	if s3fs.cfg.Anonymous {
+		args = append(args, "-o", "public_bucket=1", "-o", "ro")
+	}
+	if s3fs.cfg.RequesterPays {
+		args = append(args, "-o", "requester_pays")
+	}
 	if s3fs.region != "" {
 		args = append(args, "-o", fmt.Sprintf("endpoint=%s", s3fs.region))
 	}
+	if s3fs.meta.CapacityBytes > 0 {
+		args = append(args, "-o", fmt.Sprintf("bucket_size=%d", s3fs.meta.CapacityBytes))
+	}
+	if s3fs.cfg.Insecure {
+		args = append(args, "-o", "no_check_certificate", "-o", "ssl_verify_hostname=0")
+	}
+	files, err := writeTLSFiles(s3fs.cfg)
+	if err != nil {
+		return err
+	}
+	envs := append([]string{}, s3fs.envs...)
+	if files.CABundle != "" {
+		// s3fs has no option for it, but its libcurl reads this
+		envs = append(envs, "CURL_CA_BUNDLE="+files.CABundle)
+	}
+	enc, err := s3.VolumeEncryption(s3fs.meta.Parameters, s3fs.cfg)
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		switch enc.Mode {
+		case s3.EncryptionSSES3:
+			args = append(args, "-o", "use_sse")
+		case s3.EncryptionSSEKMS:
+			if enc.KMSKeyID != "" {
+				args = append(args, "-o", "use_sse=kmsid:"+enc.KMSKeyID)
+			} else {
+				args = append(args, "-o", "use_sse=kmsid")
+			}
+		case s3.EncryptionSSEC:
+			keyFile, err := writeS3fsSSECKey(enc)
+			if err != nil {
+				return err
+			}
+			args = append(args, "-o", "use_sse=custom:"+keyFile)
+		}
+	}
 	args = append(args, s3fs.meta.MountOptions...)
-	return fuseMount(target, s3fsCmd, args, nil)
+	return fuseMount(target, s3fsCmd, args, envs)
 }
 
 func writes3fsPass(pwFileContent string) error {
@@ -63,3 +150,14 @@ func writes3fsPass(pwFileContent string) error {
 	}
 	return nil
 }
+
+// writeS3fsSSECKey writes the key of EncryptionSSEC to a file for s3fs, which
+// only takes it from there
+func writeS3fsSSECKey(enc *s3.Encryption) (string, error) {
+	sum := sha256.Sum256(enc.CustomerKey)
+	keyFileName := fmt.Sprintf("%s/.s3fs-sse-c-%x", os.Getenv("HOME"), sum[:8])
+	if err := os.WriteFile(keyFileName, []byte(enc.CustomerKeyBase64()+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return keyFileName, nil
+}
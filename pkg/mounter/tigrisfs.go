@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -16,22 +17,38 @@ import (
 
 // Implements Mounter
 type tigrisfsMounter struct {
-	meta            *s3.FSMeta
-	endpoint        string
-	region          string
-	accessKeyID     string
-	secretAccessKey string
-	binary          string
+	meta     *s3.FSMeta
+	endpoint string
+	region   string
+	envs     []string
+	binary   string
+	cfg      *s3.Config
+	// tls and creds are set by Mount
+	tls   *tlsFiles
+	creds *credentialFiles
 }
 
 func newTigrisFSMounter(meta *s3.FSMeta, cfg *s3.Config, binary string) (Mounter, error) {
+	if cfg.Anonymous || cfg.RequesterPays {
+		return nil, fmt.Errorf("%s supports neither anonymous access nor requester pays buckets", binary)
+	}
+	envs, err := credentialEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := cfg.EndpointURL()
+	if err != nil {
+		return nil, err
+	}
 	return &tigrisfsMounter{
-		meta:            meta,
-		endpoint:        cfg.Endpoint,
-		region:          cfg.Region,
-		accessKeyID:     cfg.AccessKeyID,
-		secretAccessKey: cfg.SecretAccessKey,
-		binary:          binary,
+		meta:     meta,
+		endpoint: endpoint,
+		region:   cfg.Region,
+		envs:     envs,
+		binary:   binary,
+		cfg:      cfg,
+		tls:      &tlsFiles{},
+		creds:    &credentialFiles{},
 	}, nil
 }
 
@@ -74,13 +91,28 @@ func (tigrisfs *tigrisfsMounter) MountDirect(target string, args []string) error
 		"-o", "allow_other",
 		"--log-file", "/dev/stderr",
 	}, args...)
-	envs := []string{
-		"AWS_ACCESS_KEY_ID=" + tigrisfs.accessKeyID,
-		"AWS_SECRET_ACCESS_KEY=" + tigrisfs.secretAccessKey,
+	envs := append(tigrisfs.tlsEnv(tlsDir), tigrisfs.creds.env(credentialsDir)...)
+	return fuseMount(target, tigrisfs.binary, args, append(envs, tigrisfs.envs...))
+}
+
+// tlsEnv returns the environment variables passing the TLS files to the AWS
+// SDK, as found in dir
+func (tigrisfs *tigrisfsMounter) tlsEnv(dir string) []string {
+	var envs []string
+	for _, f := range []struct{ env, path string }{
+		{"AWS_CA_BUNDLE", tigrisfs.tls.CABundle},
+		{"AWS_SDK_GO_CLIENT_TLS_CERT", tigrisfs.tls.ClientCert},
+		{"AWS_SDK_GO_CLIENT_TLS_KEY", tigrisfs.tls.ClientKey},
+	} {
+		if f.path != "" {
+			envs = append(envs, f.env+"="+filepath.Join(dir, filepath.Base(f.path)))
+		}
 	}
-	return fuseMount(target, tigrisfs.binary, args, envs)
+	return envs
 }
 
+// Mount starts GeeseFS or TigrisFS. Neither has an option for the size of the
+// filesystem, so df always shows a huge one and meta.CapacityBytes is unused.
 func (tigrisfs *tigrisfsMounter) Mount(target, volumeID string) error {
 	ctx := context.Background()
 	fullPath := fmt.Sprintf("%s:%s", tigrisfs.meta.BucketName, tigrisfs.meta.Prefix)
@@ -88,6 +120,39 @@ func (tigrisfs *tigrisfsMounter) Mount(target, volumeID string) error {
 	if tigrisfs.region != "" {
 		args = append(args, "--region", tigrisfs.region)
 	}
+	pathStyle, err := tigrisfs.cfg.PathStyle(tigrisfs.meta.BucketName)
+	if err != nil {
+		return err
+	}
+	if !pathStyle {
+		args = append(args, "--subdomain")
+	}
+	if tigrisfs.cfg.Insecure {
+		args = append(args, "--no-verify-ssl")
+	}
+	tls, err := writeTLSFiles(tigrisfs.cfg)
+	if err != nil {
+		return err
+	}
+	tigrisfs.tls = tls
+	creds, err := writeCredentialFiles(tigrisfs.cfg, volumeID)
+	if err != nil {
+		return err
+	}
+	tigrisfs.creds = creds
+	enc, err := s3.VolumeEncryption(tigrisfs.meta.Parameters, tigrisfs.cfg)
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		switch enc.Mode {
+		case s3.EncryptionSSES3:
+			args = append(args, "--sse")
+		case s3.EncryptionSSEKMS:
+			// An empty key ID selects the default key of the account
+			args = append(args, "--sse-kms="+enc.KMSKeyID)
+		}
+	}
 	args = append(
 		args,
 		"--setuid", "65534", // nobody. drop root privileges
@@ -146,8 +211,9 @@ func (tigrisfs *tigrisfsMounter) setupSystemdMount(ctx context.Context, volumeID
 	}
 	pluginDir := cmp.Or(os.Getenv("PLUGIN_DIR"), "/var/lib/kubelet/plugins/ca.gmem.s3.csi")
 	args = append([]string{pluginDir + "/tigrisfs", "-f", "-o", "allow_other", "--endpoint", tigrisfs.endpoint}, args...)
-	glog.Info("starting s3 mount using systemd: " + strings.Join(args, " "))
+	glog.Info("starting s3 mount using systemd: " + strings.Join(loggableArgs(args), " "))
 	unitName := fmt.Sprintf("%s-%s.service", tigrisfs.binary, systemd.PathBusEscape(volumeID))
+	envs := append(tigrisfs.tlsEnv(pluginDir+"/tls"), tigrisfs.creds.env(pluginDir+"/aws")...)
 	newProps := []systemd.Property{
 		{
 			Name:  "Description",
@@ -155,11 +221,8 @@ func (tigrisfs *tigrisfsMounter) setupSystemdMount(ctx context.Context, volumeID
 		},
 		systemd.PropExecStart(args, false),
 		{
-			Name: "Environment",
-			Value: dbus.MakeVariant([]string{
-				fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", tigrisfs.accessKeyID),
-				fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", tigrisfs.secretAccessKey),
-			}),
+			Name:  "Environment",
+			Value: dbus.MakeVariant(append(envs, tigrisfs.envs...)),
 		},
 		{
 			Name:  "CollectMode",
@@ -0,0 +1,49 @@
+package mounter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+)
+
+// tlsDir is where the TLS material of secrets is written, as the mounters
+// only take files. It is in the plugin directory, which is shared with the
+// host for mounters started by systemd.
+const tlsDir = "/csi/tls"
+
+// tlsFiles are the paths of the TLS material of a secret, empty if unset
+type tlsFiles struct {
+	CABundle   string
+	ClientCert string
+	ClientKey  string
+}
+
+// writeTLSFiles writes the TLS material of cfg to files named after their
+// content, so volumes with the same secret share them
+func writeTLSFiles(cfg *s3.Config) (*tlsFiles, error) {
+	files := &tlsFiles{}
+	for _, f := range []struct {
+		content string
+		path    *string
+	}{
+		{cfg.CABundle, &files.CABundle},
+		{cfg.TLSClientCert, &files.ClientCert},
+		{cfg.TLSClientKey, &files.ClientKey},
+	} {
+		if f.content == "" {
+			continue
+		}
+		if err := os.MkdirAll(tlsDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", tlsDir, err)
+		}
+		path := filepath.Join(tlsDir, fmt.Sprintf("%x.pem", sha256.Sum256([]byte(f.content))))
+		if err := os.WriteFile(path, []byte(f.content), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		*f.path = path
+	}
+	return files, nil
+}
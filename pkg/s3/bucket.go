@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// BucketOptions are the settings of a bucket created for volumes
+type BucketOptions struct {
+	// Region is the location constraint of the bucket, Config.Region if empty
+	Region     string
+	Versioning bool
+	// ObjectLock enables object lock, and with it versioning. RetentionMode
+	// and RetentionDays set the default retention of new objects.
+	ObjectLock    bool
+	RetentionMode minio.RetentionMode
+	RetentionDays uint
+	Tags          map[string]string
+	// Encryption is the default encryption of new objects, unless it is
+	// EncryptionSSEC
+	Encryption *Encryption
+}
+
+// configureBucket applies the settings of opts to a new bucket
+func (client *s3Client) configureBucket(ctx context.Context, bucketName string, opts BucketOptions) error {
+	if opts.Versioning && !opts.ObjectLock {
+		if err := client.minio.EnableVersioning(ctx, bucketName); err != nil {
+			return fmt.Errorf("failed to enable versioning: %w", err)
+		}
+	}
+	if opts.ObjectLock && opts.RetentionMode != "" {
+		unit := minio.Days
+		if err := client.minio.SetObjectLockConfig(ctx, bucketName, &opts.RetentionMode, &opts.RetentionDays, &unit); err != nil {
+			return fmt.Errorf("failed to set default retention: %w", err)
+		}
+	}
+	if cfg := opts.Encryption.bucketConfig(); cfg != nil {
+		if err := client.minio.SetBucketEncryption(ctx, bucketName, cfg); err != nil {
+			return fmt.Errorf("failed to set default encryption: %w", err)
+		}
+	}
+	if len(opts.Tags) > 0 {
+		bucketTags, err := tags.MapToBucketTags(opts.Tags)
+		if err != nil {
+			return err
+		}
+		if err := client.minio.SetBucketTagging(ctx, bucketName, bucketTags); err != nil {
+			return fmt.Errorf("failed to tag bucket: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckBucket returns an error if an existing bucket doesn't have the
+// settings of opts
+func (client *s3Client) CheckBucket(ctx context.Context, bucketName string, opts BucketOptions) error {
+	var mismatches []string
+	if opts.Region != "" {
+		region, err := client.minio.GetBucketLocation(ctx, bucketName)
+		if err != nil {
+			return err
+		}
+		if region != opts.Region {
+			mismatches = append(mismatches, fmt.Sprintf("it is in region %q instead of %q", region, opts.Region))
+		}
+	}
+	if opts.Versioning || opts.ObjectLock {
+		cfg, err := client.minio.GetBucketVersioning(ctx, bucketName)
+		if err != nil {
+			return err
+		}
+		if !cfg.Enabled() {
+			mismatches = append(mismatches, "versioning is not enabled")
+		}
+	}
+	if opts.ObjectLock {
+		enabled, mode, validity, unit, err := client.minio.GetObjectLockConfig(ctx, bucketName)
+		if err != nil && ClassifyError(err) != ErrNotFound {
+			return err
+		}
+		switch {
+		case enabled != "Enabled":
+			mismatches = append(mismatches, "object lock is not enabled")
+		case opts.RetentionMode != "" && (mode == nil || *mode != opts.RetentionMode ||
+			validity == nil || *validity != opts.RetentionDays || unit == nil || *unit != minio.Days):
+			mismatches = append(mismatches, fmt.Sprintf("its default retention is not %s for %d days",
+				opts.RetentionMode, opts.RetentionDays))
+		}
+	}
+	if len(opts.Tags) > 0 {
+		bucketTags, err := client.minio.GetBucketTagging(ctx, bucketName)
+		existing := map[string]string{}
+		if err == nil {
+			existing = bucketTags.ToMap()
+		} else if ClassifyError(err) != ErrNotFound {
+			return err
+		}
+		for k, v := range opts.Tags {
+			if existing[k] != v {
+				mismatches = append(mismatches, fmt.Sprintf("it is not tagged %s=%s", k, v))
+			}
+		}
+	}
+	if want := opts.Encryption.bucketConfig(); want != nil {
+		cfg, err := client.minio.GetBucketEncryption(ctx, bucketName)
+		if err != nil && ClassifyError(err) != ErrNotFound {
+			return err
+		}
+		wantRule := want.Rules[0].Apply
+		found := false
+		if cfg != nil {
+			for _, rule := range cfg.Rules {
+				if rule.Apply.SSEAlgorithm == wantRule.SSEAlgorithm &&
+					(wantRule.KmsMasterKeyID == "" || rule.Apply.KmsMasterKeyID == wantRule.KmsMasterKeyID) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			mismatches = append(mismatches, fmt.Sprintf("its default encryption is not %s", opts.Encryption.Mode))
+		}
+	}
+	if len(mismatches) > 0 {
+		return &BucketMismatchError{Bucket: bucketName, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// BucketMismatchError is an existing bucket without the requested settings
+type BucketMismatchError struct {
+	Bucket     string
+	Mismatches []string
+}
+
+func (e *BucketMismatchError) Error() string {
+	return fmt.Sprintf("bucket %s doesn't match the requested settings: %s", e.Bucket, strings.Join(e.Mismatches, ", "))
+}
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DefaultClientIdleTimeout is how long a ClientCache keeps unused clients
+const DefaultClientIdleTimeout = 10 * time.Minute
+
+// ClientCache reuses clients, and their connections, across requests with
+// the same configuration. It is safe for concurrent use.
+type ClientCache struct {
+	idleTimeout time.Duration
+	// defaultProxy is used by configurations without a proxy
+	defaultProxy ProxyConfig
+
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+type cachedClient struct {
+	client   *s3Client
+	lastUsed time.Time
+}
+
+// NewClientCache returns a cache which drops clients unused for idleTimeout.
+// Clients of configurations without a proxy use defaultProxy.
+func NewClientCache(idleTimeout time.Duration, defaultProxy ProxyConfig) *ClientCache {
+	return &ClientCache{
+		idleTimeout:  idleTimeout,
+		defaultProxy: defaultProxy,
+		clients:      make(map[string]*cachedClient),
+	}
+}
+
+// Client returns the client for cfg, creating it if the cache has none
+func (c *ClientCache) Client(cfg *Config) (*s3Client, error) {
+	if cfg.Proxy.IsZero() && !c.defaultProxy.IsZero() {
+		withProxy := *cfg
+		withProxy.Proxy = c.defaultProxy
+		cfg = &withProxy
+	}
+	key, err := cfg.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if client := c.lookup(key); client != nil {
+		return client, nil
+	}
+	// Creating a client may assume a role over the network, so it's done
+	// without holding mu to not block the lookups of other volumes
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if cached, ok := c.clients[key]; ok {
+		// Another request created the same client meanwhile
+		client.transport.CloseIdleConnections()
+		cached.lastUsed = now
+		return cached.client, nil
+	}
+	c.clients[key] = &cachedClient{client: client, lastUsed: now}
+	return client, nil
+}
+
+// lookup returns the cached client of key, or nil if the cache has none
+func (c *ClientCache) lookup(key string) *s3Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.evict(now)
+	cached, ok := c.clients[key]
+	if !ok {
+		return nil
+	}
+	cached.lastUsed = now
+	return cached.client
+}
+
+// ClientFromSecret returns the client for the configuration of a secret
+func (c *ClientCache) ClientFromSecret(secret map[string]string) (*s3Client, error) {
+	cfg, err := ConfigFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return c.Client(cfg)
+}
+
+// evict drops the clients which were idle for too long, it must be called
+// with mu held
+func (c *ClientCache) evict(now time.Time) {
+	for key, cached := range c.clients {
+		if now.Sub(cached.lastUsed) > c.idleTimeout {
+			glog.V(4).Infof("Dropping S3 client for %s, unused since %v", cached.client.Config.Endpoint, cached.lastUsed)
+			cached.client.transport.CloseIdleConnections()
+			delete(c.clients, key)
+		}
+	}
+}
+
+// fingerprint identifies the configuration without keeping its secrets in
+// the cache keys
+func (cfg *Config) fingerprint() (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
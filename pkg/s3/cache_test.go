@@ -0,0 +1,82 @@
+package s3_test
+
+import (
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClientCache", func() {
+	secret := map[string]string{
+		"endpoint":        "http://127.0.0.1:9000",
+		"accessKeyID":     "key",
+		"secretAccessKey": "secret",
+	}
+
+	It("reuses the client of the same secret", func() {
+		cache := s3.NewClientCache(time.Minute, s3.ProxyConfig{})
+		client, err := cache.ClientFromSecret(secret)
+		Expect(err).NotTo(HaveOccurred())
+		again, err := cache.ClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "key",
+			"secretAccessKey": "secret",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(client))
+		Expect(cache.Len()).To(Equal(1))
+	})
+
+	It("keeps one client for concurrent requests", func() {
+		cache := s3.NewClientCache(time.Minute, s3.ProxyConfig{})
+		clients := make(chan interface{}, 8)
+		for i := 0; i < cap(clients); i++ {
+			go func() {
+				defer GinkgoRecover()
+				client, err := cache.ClientFromSecret(secret)
+				Expect(err).NotTo(HaveOccurred())
+				clients <- client
+			}()
+		}
+		first := <-clients
+		for i := 1; i < cap(clients); i++ {
+			Expect(<-clients).To(BeIdenticalTo(first))
+		}
+		Expect(cache.Len()).To(Equal(1))
+	})
+
+	It("creates a client per credentials", func() {
+		cache := s3.NewClientCache(time.Minute, s3.ProxyConfig{})
+		client, err := cache.ClientFromSecret(secret)
+		Expect(err).NotTo(HaveOccurred())
+		other, err := cache.ClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "key",
+			"secretAccessKey": "other",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(BeIdenticalTo(client))
+	})
+
+	It("drops idle clients", func() {
+		cache := s3.NewClientCache(10*time.Millisecond, s3.ProxyConfig{})
+		client, err := cache.ClientFromSecret(secret)
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(20 * time.Millisecond)
+		again, err := cache.ClientFromSecret(secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).NotTo(BeIdenticalTo(client))
+	})
+
+	It("doesn't cache invalid configurations", func() {
+		cache := s3.NewClientCache(time.Minute, s3.ProxyConfig{})
+		_, err := cache.ClientFromSecret(map[string]string{"caBundle": "not a certificate"})
+		Expect(err).To(HaveOccurred())
+		Expect(cache.Len()).To(Equal(0))
+		_, err = cache.ClientFromSecret(map[string]string{"caBundle": "not a certificate"})
+		Expect(err).To(HaveOccurred())
+		Expect(cache.Len()).To(Equal(0))
+	})
+})
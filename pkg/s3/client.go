@@ -3,101 +3,278 @@ package s3
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
+	"path"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
 type s3Client struct {
 	Config *Config
 	minio  *minio.Client
-	ctx    context.Context
+	// transport and creds are kept for requests to the MinIO admin API
+	transport *http.Transport
+	creds     *credentials.Credentials
+	// basePath is the path of the endpoint, prepended to every request
+	basePath string
 }
 
 // Config holds values to configure the driver
 type Config struct {
 	AccessKeyID     string
 	SecretAccessKey string
-	Region          string
-	Endpoint        string
-	Mounter         string
-	Insecure        bool
+	// SessionToken is set for temporary credentials
+	SessionToken string
+	// RoleARN is assumed with the credentials above if set
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	// RoleDurationSeconds is how long assumed credentials are valid
+	RoleDurationSeconds int
+	// WebIdentityTokenFile is exchanged for credentials of RoleARN
+	WebIdentityTokenFile string
+	// WebIdentityToken is used instead of WebIdentityTokenFile if set, it is
+	// never read from a secret
+	WebIdentityToken string
+	// STSEndpoint defaults to the regional AWS STS endpoint for AWS S3 and
+	// to Endpoint otherwise
+	STSEndpoint string
+	Region      string
+	Endpoint    string
+	// AddressingStyle is one of AddressingPath, AddressingVirtual or
+	// AddressingAuto, the default
+	AddressingStyle string
+	// Anonymous requests aren't signed and volumes are mounted read-only,
+	// RequesterPays bills the requests to the caller instead of the owner
+	Anonymous     bool
+	RequesterPays bool
+	Mounter       string
+	Insecure      bool
+	// Proxy is used by the driver and passed to the mounters
+	Proxy ProxyConfig
+	// CABundle holds PEM encoded certificates trusted on top of the system
+	// ones, TLSClientCert and TLSClientKey a PEM encoded client certificate
+	CABundle      string
+	TLSClientCert string
+	TLSClientKey  string
+	// SSECustomerKey is the base64 encoded key of volumes encrypted with
+	// EncryptionSSEC, SSEKMSKeyID the default KMS key of EncryptionSSEKMS
+	SSECustomerKey string
+	SSEKMSKeyID    string
+	// CryptPassword and CryptPassword2, the salt, encrypt the files of
+	// volumes mounted with rclone crypt
+	CryptPassword  string
+	CryptPassword2 string
 }
 
+const (
+	// VolumeMetaKey is the name of the object holding volume metadata. It is
+	// stored at the root of the volume's bucket or prefix.
+	VolumeMetaKey = ".csi-s3-meta.json"
+
+	// SnapshotMetaKey is the name of the object describing a snapshot. It is
+	// stored at the root of the snapshot's bucket or prefix.
+	SnapshotMetaKey = ".csi-s3-snapshot.json"
+
+	// VolumeLockKey is the name of the object naming the node which has
+	// staged a single-node volume. It is stored next to VolumeMetaKey.
+	VolumeLockKey = ".csi-s3-lock.json"
+
+	// RemovalMarkerKey is the name of the object holding the progress of the
+	// removal of a volume. It is stored next to VolumeMetaKey.
+	RemovalMarkerKey = ".csi-s3-removal.json"
+
+	// TrashPrefix holds volumes deleted with the trash policy, in directories
+	// named after their time of deletion in TrashTimeFormat
+	TrashPrefix     = ".trash"
+	TrashTimeFormat = "20060102T150405Z"
+
+	// maxCopyObjectSize is the largest object that can be copied with a
+	// single CopyObject request, larger ones need a multipart copy.
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+)
+
+// FSMetaVersion is the latest version of the FSMeta format. Version 0 is the
+// same as 1, it was written before the field was added.
+const FSMetaVersion = 1
+
+// FSMeta describes a volume. It is stored with the volume in VolumeMetaKey.
 type FSMeta struct {
+	Version       int      `json:"Version"`
 	BucketName    string   `json:"Name"`
 	Prefix        string   `json:"Prefix"`
 	Mounter       string   `json:"Mounter"`
 	MountOptions  []string `json:"MountOptions"`
 	CapacityBytes int64    `json:"CapacityBytes"`
+	// Parameters is the volume context the volume was created with
+	Parameters map[string]string `json:"Parameters,omitempty"`
+}
+
+// VolumeLock is the lease of a volume by a node. It must be renewed
+// periodically, the node is considered gone otherwise.
+type VolumeLock struct {
+	NodeID    string    `json:"NodeID"`
+	RenewTime time.Time `json:"RenewTime"`
+	// ETag identifies the lock object it was read from
+	ETag string `json:"-"`
+}
+
+// SnapshotMeta describes where a snapshot was taken from
+type SnapshotMeta struct {
+	SourceVolumeID string    `json:"SourceVolumeID"`
+	CreationTime   time.Time `json:"CreationTime"`
+	SizeBytes      int64     `json:"SizeBytes"`
 }
 
 func NewClient(cfg *Config) (*s3Client, error) {
 	var client = &s3Client{}
 
 	client.Config = cfg
-	u, err := url.Parse(client.Config.Endpoint)
+	u, err := ParseEndpoint(client.Config.Endpoint)
 	if err != nil {
 		return nil, err
 	}
-	ssl := u.Scheme == "https"
-	endpoint := u.Hostname()
-	if u.Port() != "" {
-		endpoint = u.Hostname() + ":" + u.Port()
+	if err := checkAddressingStyle(cfg.AddressingStyle); err != nil {
+		return nil, err
 	}
 
-	var transport = &http.Transport{}
-	if client.Config.Insecure {
-		tlsConfig := &tls.Config{}
-		tlsConfig.InsecureSkipVerify = true
-		transport.TLSClientConfig = tlsConfig
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, err
 	}
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Transport: transport,
-		Creds:     credentials.NewStaticV4(client.Config.AccessKeyID, client.Config.SecretAccessKey, ""),
-		Region:    client.Config.Region,
-		Secure:    ssl,
+	creds, err := cfg.credentials(transport)
+	if err != nil {
+		return nil, err
+	}
+	var roundTripper http.RoundTripper = transport
+	if u.Path != "" || cfg.RequesterPays {
+		// Uploads over plain http are signed chunk by chunk, which
+		// requestTransport can't sign again
+		if u.Scheme == "http" && !cfg.Anonymous {
+			return nil, fmt.Errorf("endpoint %s must use https with a path or requesterPays", u)
+		}
+		header := http.Header{}
+		if cfg.RequesterPays {
+			header.Set("X-Amz-Request-Payer", "requester")
+		}
+		roundTripper = &requestTransport{
+			base:   transport,
+			prefix: u.Path,
+			header: header,
+			creds: func() (string, string, string, error) {
+				v, err := creds.Get()
+				return v.AccessKeyID, v.SecretAccessKey, v.SessionToken, err
+			},
+		}
+	}
+	// u.Host keeps the brackets of IPv6 literals
+	minioClient, err := minio.New(u.Host, &minio.Options{
+		Transport:    roundTripper,
+		Creds:        creds,
+		Region:       client.Config.Region,
+		Secure:       u.Scheme == "https",
+		BucketLookup: cfg.bucketLookup(),
 	})
 	if err != nil {
 		return nil, err
 	}
 	client.minio = minioClient
-	client.ctx = context.Background()
+	client.transport = transport
+	client.creds = creds
+	client.basePath = u.Path
 	return client, nil
 }
 
 func NewClientFromSecret(secret map[string]string) (*s3Client, error) {
+	cfg, err := ConfigFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(cfg)
+}
+
+// ConfigFromSecret reads the configuration of a secret
+func ConfigFromSecret(secret map[string]string) (*Config, error) {
 	insecure, _ := strconv.ParseBool(secret["insecure"])
-	return NewClient(&Config{
-		AccessKeyID:     secret["accessKeyID"],
-		SecretAccessKey: secret["secretAccessKey"],
-		Region:          secret["region"],
-		Endpoint:        secret["endpoint"],
+	anonymous, _ := strconv.ParseBool(secret["anonymous"])
+	requesterPays, _ := strconv.ParseBool(secret["requesterPays"])
+	if err := checkAddressingStyle(secret["addressingStyle"]); err != nil {
+		return nil, err
+	}
+	var durationSeconds int
+	if secret["roleDurationSeconds"] != "" {
+		var err error
+		if durationSeconds, err = strconv.Atoi(secret["roleDurationSeconds"]); err != nil {
+			return nil, fmt.Errorf("invalid roleDurationSeconds: %v", err)
+		}
+	}
+	return &Config{
+		AccessKeyID:          secret["accessKeyID"],
+		SecretAccessKey:      secret["secretAccessKey"],
+		SessionToken:         secret["sessionToken"],
+		RoleARN:              secret["roleARN"],
+		ExternalID:           secret["externalID"],
+		RoleSessionName:      secret["roleSessionName"],
+		RoleDurationSeconds:  durationSeconds,
+		WebIdentityTokenFile: secret["webIdentityTokenFile"],
+		STSEndpoint:          secret["stsEndpoint"],
+		Region:               secret["region"],
+		Endpoint:             secret["endpoint"],
+		AddressingStyle:      secret["addressingStyle"],
+		Anonymous:            anonymous,
+		RequesterPays:        requesterPays,
 		// Mounter is set in the volume preferences, not secrets
-		Mounter:  "",
-		Insecure: insecure,
-	})
+		Mounter:        "",
+		Insecure:       insecure,
+		CABundle:       secret["caBundle"],
+		TLSClientCert:  secret["tlsClientCert"],
+		TLSClientKey:   secret["tlsClientKey"],
+		SSECustomerKey: secret["sseCustomerKey"],
+		SSEKMSKeyID:    secret["sseKMSKeyID"],
+		CryptPassword:  secret["cryptPassword"],
+		CryptPassword2: secret["cryptPassword2"],
+		Proxy: ProxyConfig{
+			HTTPProxy:  secret["httpProxy"],
+			HTTPSProxy: secret["httpsProxy"],
+			NoProxy:    secret["noProxy"],
+		},
+	}, nil
 }
 
-func (client *s3Client) BucketExists(bucketName string) (bool, error) {
-	return client.minio.BucketExists(client.ctx, bucketName)
+func (client *s3Client) BucketExists(ctx context.Context, bucketName string) (bool, error) {
+	return client.minio.BucketExists(ctx, bucketName)
 }
 
-func (client *s3Client) CreateBucket(bucketName string) error {
-	return client.minio.MakeBucket(client.ctx, bucketName, minio.MakeBucketOptions{Region: client.Config.Region})
+// CreateBucket creates a bucket with the settings of opts. It may exist
+// already if it is owned by the caller, e.g. when a request is retried.
+func (client *s3Client) CreateBucket(ctx context.Context, bucketName string, opts BucketOptions) error {
+	region := opts.Region
+	if region == "" {
+		region = client.Config.Region
+	}
+	err := client.minio.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{
+		Region:        region,
+		ObjectLocking: opts.ObjectLock,
+	})
+	if err != nil && ClassifyError(err) != ErrAlreadyOwned {
+		return err
+	}
+	return client.configureBucket(ctx, bucketName, opts)
 }
 
-func (client *s3Client) CreatePrefix(bucketName string, prefix string) error {
+func (client *s3Client) CreatePrefix(ctx context.Context, bucketName string, prefix string) error {
 	if prefix != "" {
 		_, err := client.minio.PutObject(
-			client.ctx, bucketName, prefix+"/", bytes.NewReader([]byte("")),
+			ctx, bucketName, prefix+"/", bytes.NewReader([]byte("")),
 			0, minio.PutObjectOptions{},
 		)
 		if err != nil {
@@ -107,131 +284,768 @@ func (client *s3Client) CreatePrefix(bucketName string, prefix string) error {
 	return nil
 }
 
-func (client *s3Client) RemovePrefix(bucketName string, prefix string) error {
-	var err error
-
-	if err = client.removeObjects(bucketName, prefix); err == nil {
-		return client.minio.RemoveObject(client.ctx, bucketName, prefix, minio.RemoveObjectOptions{})
+// PrefixExists tells whether there is any object in bucketName/prefix
+func (client *s3Client) PrefixExists(ctx context.Context, bucketName, prefix string) (bool, error) {
+	// Stop listing after the first object
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for object := range client.minio.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix + "/", MaxKeys: 1}) {
+		if object.Err != nil {
+			return false, object.Err
+		}
+		return true, nil
 	}
+	return false, nil
+}
 
-	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
-
-	if err = client.removeObjectsOneByOne(bucketName, prefix); err == nil {
-		return client.minio.RemoveObject(client.ctx, bucketName, prefix, minio.RemoveObjectOptions{})
+// ListBuckets returns the names of all buckets visible to the client
+func (client *s3Client) ListBuckets(ctx context.Context) ([]string, error) {
+	buckets, err := client.minio.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	return err
+	names := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		names = append(names, bucket.Name)
+	}
+	return names, nil
 }
 
-func (client *s3Client) RemoveBucket(bucketName string) error {
-	var err error
+// ListPrefixes returns the top-level "directories" of a bucket, without the
+// trailing slash
+func (client *s3Client) ListPrefixes(ctx context.Context, bucketName string) ([]string, error) {
+	return client.listPrefixes(ctx, bucketName, "")
+}
 
-	if err = client.removeObjects(bucketName, ""); err == nil {
-		return client.minio.RemoveBucket(client.ctx, bucketName)
+func (client *s3Client) listPrefixes(ctx context.Context, bucketName, parent string) ([]string, error) {
+	var prefixes []string
+	for object := range client.minio.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: parent}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			prefixes = append(prefixes, strings.TrimSuffix(object.Key, "/"))
+		}
 	}
+	return prefixes, nil
+}
 
-	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
-
-	if err = client.removeObjectsOneByOne(bucketName, ""); err == nil {
-		return client.minio.RemoveBucket(client.ctx, bucketName)
+// PrefixUsage returns the total size of all objects of a prefix, or of the
+// whole bucket if prefix is empty
+func (client *s3Client) PrefixUsage(ctx context.Context, bucketName, prefix string) (int64, error) {
+	if prefix != "" {
+		prefix += "/"
+	}
+	var usage int64
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
+	for object := range client.minio.ListObjects(ctx, bucketName, opts) {
+		if object.Err != nil {
+			return 0, object.Err
+		}
+		usage += object.Size
 	}
+	return usage, nil
+}
 
-	return err
+// CopyPrefix copies all objects of srcPrefix in srcBucket to dstPrefix in
+// dstBucket using server-side copy, encrypting the copies with enc. Empty
+// prefixes stand for the whole bucket. Objects encrypted with EncryptionSSEC
+// must be copied with the same key. Driver metadata objects in the root of
+// srcPrefix and the trash of a whole bucket are not copied. Returns the total
+// size of copied objects.
+func (client *s3Client) CopyPrefix(ctx context.Context, srcBucket, srcPrefix, dstBucket, dstPrefix string, enc *Encryption) (int64, error) {
+	return client.copyObjects(ctx, srcBucket, srcPrefix, dstBucket, dstPrefix, false, enc)
 }
 
-func (client *s3Client) removeObjects(bucketName, prefix string) error {
-	objectsCh := make(chan minio.ObjectInfo)
+func (client *s3Client) copyObjects(ctx context.Context, srcBucket, srcPrefix, dstBucket, dstPrefix string, withMeta bool, enc *Encryption) (int64, error) {
+	sse, err := enc.serverSide()
+	if err != nil {
+		return 0, err
+	}
+	parallelism := 16
+	objectsCh := make(chan minio.ObjectInfo, parallelism)
+	guardCh := make(chan int, parallelism)
 	var listErr error
+	var totalSize int64 = 0
+	var copyErrors int64 = 0
+
+	listPrefix := ""
+	if srcPrefix != "" {
+		listPrefix = srcPrefix + "/"
+	}
 
 	go func() {
 		defer close(objectsCh)
 
-		for object := range client.minio.ListObjects(
-			client.ctx,
-			bucketName,
-			minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		for object := range client.minio.ListObjects(ctx, srcBucket,
+			minio.ListObjectsOptions{Prefix: listPrefix, Recursive: true}) {
 			if object.Err != nil {
 				listErr = object.Err
 				return
 			}
-			objectsCh <- object
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+				listErr = ctx.Err()
+				return
+			}
 		}
+		listErr = ctx.Err()
 	}()
 
+	for object := range objectsCh {
+		name := strings.TrimPrefix(object.Key, listPrefix)
+		if name == "" || (srcPrefix == "" && strings.HasPrefix(name, TrashPrefix+"/")) {
+			continue
+		}
+		if name == VolumeLockKey || name == RemovalMarkerKey || (!withMeta && (name == VolumeMetaKey || name == SnapshotMetaKey)) {
+			continue
+		}
+		dstKey := name
+		if dstPrefix != "" {
+			dstKey = dstPrefix + "/" + name
+		}
+		guardCh <- 1
+		go func(obj minio.ObjectInfo, dstKey string) {
+			err := client.copyObject(ctx, srcBucket, obj, dstBucket, dstKey, sse)
+			if err != nil {
+				glog.Errorf("Failed to copy object %s to %s/%s, error: %s", obj.Key, dstBucket, dstKey, err)
+				atomic.AddInt64(&copyErrors, 1)
+			} else {
+				atomic.AddInt64(&totalSize, obj.Size)
+			}
+			<-guardCh
+		}(object, dstKey)
+	}
+	for i := 0; i < parallelism; i++ {
+		guardCh <- 1
+	}
+	for i := 0; i < parallelism; i++ {
+		<-guardCh
+	}
+
 	if listErr != nil {
 		glog.Error("Error listing objects", listErr)
-		return listErr
+		return 0, listErr
+	}
+	if copyErrors > 0 {
+		return 0, fmt.Errorf("failed to copy %v objects of path %s", copyErrors, path.Join(srcBucket, srcPrefix))
 	}
 
-	// TODO S1000
-	select { //nolint
-	default:
-		opts := minio.RemoveObjectsOptions{
-			GovernanceBypass: true,
+	return totalSize, nil
+}
+
+func (client *s3Client) copyObject(ctx context.Context, srcBucket string, obj minio.ObjectInfo, dstBucket, dstKey string, sse encrypt.ServerSide) error {
+	src := minio.CopySrcOptions{Bucket: srcBucket, Object: obj.Key}
+	dst := minio.CopyDestOptions{Bucket: dstBucket, Object: dstKey}
+	if sse != nil && !driverObject(obj.Key) {
+		if sse.Type() == encrypt.SSEC {
+			src.Encryption = sse
 		}
-		errorCh := client.minio.RemoveObjects(client.ctx, bucketName, objectsCh, opts)
-		haveErrWhenRemoveObjects := false
-		for e := range errorCh {
-			glog.Errorf("Failed to remove object %s, error: %s", e.ObjectName, e.Err)
-			haveErrWhenRemoveObjects = true
+		dst.Encryption = sse
+	}
+	var err error
+	if obj.Size > maxCopyObjectSize {
+		_, err = client.minio.ComposeObject(ctx, dst, src)
+	} else {
+		_, err = client.minio.CopyObject(ctx, dst, src)
+	}
+	return err
+}
+
+// GetSnapshotMeta reads the snapshot metadata stored in the root of
+// bucketName/prefix. It returns nil if there is no snapshot at this location.
+func (client *s3Client) GetSnapshotMeta(ctx context.Context, bucketName, prefix string) (*SnapshotMeta, error) {
+	meta := &SnapshotMeta{}
+	found, err := client.getJSON(ctx, bucketName, path.Join(prefix, SnapshotMetaKey), meta)
+	if err != nil || !found {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// SetSnapshotMeta writes the snapshot metadata to the root of bucketName/prefix
+func (client *s3Client) SetSnapshotMeta(ctx context.Context, bucketName, prefix string, meta *SnapshotMeta) error {
+	return client.putJSON(ctx, bucketName, path.Join(prefix, SnapshotMetaKey), meta)
+}
+
+// GetFSMeta reads the volume metadata stored in the root of bucketName/prefix.
+// It returns nil if there is no volume at this location.
+func (client *s3Client) GetFSMeta(ctx context.Context, bucketName, prefix string) (*FSMeta, error) {
+	meta := &FSMeta{}
+	found, err := client.getJSON(ctx, bucketName, path.Join(prefix, VolumeMetaKey), meta)
+	if err != nil || !found {
+		return nil, err
+	}
+	if meta.Version > FSMetaVersion {
+		return nil, fmt.Errorf("metadata of volume %s has version %d, but only %d is supported",
+			path.Join(bucketName, prefix), meta.Version, FSMetaVersion)
+	}
+	return meta, nil
+}
+
+// SetFSMeta writes the volume metadata to the root of its bucket or prefix
+func (client *s3Client) SetFSMeta(ctx context.Context, meta *FSMeta) error {
+	meta.Version = FSMetaVersion
+	return client.putJSON(ctx, meta.BucketName, path.Join(meta.Prefix, VolumeMetaKey), meta)
+}
+
+// GetVolumeLock returns the lease of a volume, or nil if it has none
+func (client *s3Client) GetVolumeLock(ctx context.Context, bucketName, prefix string) (*VolumeLock, error) {
+	lock := &VolumeLock{}
+	etag, found, err := client.getJSONETag(ctx, bucketName, path.Join(prefix, VolumeLockKey), lock)
+	if err != nil || !found {
+		return nil, err
+	}
+	lock.ETag = etag
+	return lock, nil
+}
+
+// SetVolumeLock replaces previous with lock, or creates lock if previous is
+// nil. It fails with ErrPreconditionFailed when another node wrote the lock
+// since previous was read. Endpoints without conditional writes reject them
+// as not implemented, the lock is then written unconditionally and two nodes
+// locking at the very same time may both win.
+func (client *s3Client) SetVolumeLock(ctx context.Context, bucketName, prefix string, lock, previous *VolumeLock) error {
+	key := path.Join(prefix, VolumeLockKey)
+	opts := minio.PutObjectOptions{}
+	if previous == nil {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(previous.ETag)
+	}
+	err := client.putJSONOptions(ctx, bucketName, key, lock, opts)
+	if minio.ToErrorResponse(err).Code == "NotImplemented" {
+		glog.V(4).Infof("Endpoint has no conditional writes, locking %s/%s unconditionally", bucketName, key)
+		err = client.putJSON(ctx, bucketName, key, lock)
+	}
+	return err
+}
+
+func (client *s3Client) RemoveVolumeLock(ctx context.Context, bucketName, prefix string) error {
+	return client.minio.RemoveObject(ctx, bucketName, path.Join(prefix, VolumeLockKey), minio.RemoveObjectOptions{})
+}
+
+// ListVolumes finds volumes in the root of every bucket and in their
+// top-level prefixes. The result is keyed by volume ID, which is the bucket
+// name joined with the prefix.
+func (client *s3Client) ListVolumes(ctx context.Context) (map[string]*FSMeta, error) {
+	volumes := make(map[string]*FSMeta)
+	err := client.walkLocations(ctx, func(bucketName, prefix string) (bool, error) {
+		meta, err := client.GetFSMeta(ctx, bucketName, prefix)
+		if meta != nil {
+			volumes[path.Join(bucketName, prefix)] = meta
 		}
-		if haveErrWhenRemoveObjects {
-			return fmt.Errorf("failed to remove all objects of bucket %s", bucketName)
+		return meta != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// ListSnapshots finds snapshots in the root of every bucket and in their
+// top-level prefixes. The result is keyed by snapshot location, which is
+// the bucket name joined with the prefix.
+func (client *s3Client) ListSnapshots(ctx context.Context) (map[string]*SnapshotMeta, error) {
+	snapshots := make(map[string]*SnapshotMeta)
+	err := client.walkLocations(ctx, func(bucketName, prefix string) (bool, error) {
+		meta, err := client.GetSnapshotMeta(ctx, bucketName, prefix)
+		if meta != nil {
+			snapshots[path.Join(bucketName, prefix)] = meta
 		}
+		return meta != nil, err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return snapshots, nil
+}
 
+// walkLocations calls fn for the root of every bucket and then for each of its
+// top-level prefixes, unless fn reports a match in the root of the bucket
+func (client *s3Client) walkLocations(ctx context.Context, fn func(bucketName, prefix string) (bool, error)) error {
+	buckets, err := client.ListBuckets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, bucketName := range buckets {
+		found, err := fn(bucketName, "")
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		prefixes, err := client.ListPrefixes(ctx, bucketName)
+		if err != nil {
+			return err
+		}
+		for _, prefix := range prefixes {
+			if _, err = fn(bucketName, prefix); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-// will delete files one by one without file lock
-func (client *s3Client) removeObjectsOneByOne(bucketName, prefix string) error {
-	parallelism := 16
-	objectsCh := make(chan minio.ObjectInfo, parallelism)
-	guardCh := make(chan int, parallelism)
-	var listErr error
-	var totalObjects int64 = 0
-	var removeErrors int64 = 0
+func (client *s3Client) putJSON(ctx context.Context, bucketName, key string, v interface{}) error {
+	return client.putJSONOptions(ctx, bucketName, key, v, minio.PutObjectOptions{})
+}
 
-	go func() {
-		defer close(objectsCh)
+func (client *s3Client) putJSONOptions(ctx context.Context, bucketName, key string, v interface{}, opts minio.PutObjectOptions) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	opts.ContentType = "application/json"
+	_, err = client.minio.PutObject(
+		ctx, bucketName, key, bytes.NewReader(data),
+		int64(len(data)), opts,
+	)
+	return err
+}
 
-		for object := range client.minio.ListObjects(client.ctx, bucketName,
-			minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
-			if object.Err != nil {
-				listErr = object.Err
-				return
+// getJSON decodes the object into v, reporting false if it does not exist
+func (client *s3Client) getJSON(ctx context.Context, bucketName, key string, v interface{}) (bool, error) {
+	_, found, err := client.getJSONETag(ctx, bucketName, key, v)
+	return found, err
+}
+
+// getJSONETag is getJSON also returning the ETag of the object
+func (client *s3Client) getJSONETag(ctx context.Context, bucketName, key string, v interface{}) (string, bool, error) {
+	var info minio.ObjectInfo
+	obj, err := client.minio.GetObject(ctx, bucketName, key, minio.GetObjectOptions{})
+	if err == nil {
+		defer obj.Close()
+		err = json.NewDecoder(obj).Decode(v)
+	}
+	if err == nil {
+		info, err = obj.Stat()
+	}
+	if err != nil {
+		code := minio.ToErrorResponse(err).Code
+		if code == "NoSuchKey" || code == "NoSuchBucket" || code == "InvalidBucketName" {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return info.ETag, true, nil
+}
+
+// RemoveOptions tunes the removal of objects. Zero values use the defaults.
+type RemoveOptions struct {
+	// Parallelism is how many batches are removed at once
+	Parallelism int
+	// BatchSize is how many objects are removed with one request, at most
+	// 1000
+	BatchSize int
+	// Progress is called with the number of objects removed so far
+	Progress func(removed int64)
+	// GovernanceBypass removes object versions under governance retention
+	GovernanceBypass bool
+}
+
+const (
+	defaultRemoveParallelism = 4
+	maxRemoveBatchSize       = 1000
+
+	// removalCheckpointInterval is how often the progress of a removal is
+	// saved in RemovalMarkerKey
+	removalCheckpointInterval = 10 * time.Second
+)
+
+func (opts RemoveOptions) parallelism() int {
+	if opts.Parallelism <= 0 {
+		return defaultRemoveParallelism
+	}
+	return opts.Parallelism
+}
+
+func (opts RemoveOptions) batchSize() int {
+	if opts.BatchSize <= 0 || opts.BatchSize > maxRemoveBatchSize {
+		return maxRemoveBatchSize
+	}
+	return opts.BatchSize
+}
+
+// removalMarker is the progress of the removal of a volume. It is stored in
+// RemovalMarkerKey while the volume is removed.
+type removalMarker struct {
+	LastKey    string    `json:"LastKey"`
+	Removed    int64     `json:"Removed"`
+	UpdateTime time.Time `json:"UpdateTime"`
+	// TrashTime is the time of deletion of a volume moved to the trash
+	TrashTime time.Time `json:"TrashTime,omitempty"`
+}
+
+// RemovePrefix removes all objects of a prefix, with all their versions in a
+// versioned bucket. The volume metadata object is removed last, so it's still
+// there if removal fails halfway.
+func (client *s3Client) RemovePrefix(ctx context.Context, bucketName string, prefix string, opts RemoveOptions) error {
+	return client.removeVolume(ctx, bucketName, prefix, prefix, opts)
+}
+
+// RemoveBucket removes all objects of a bucket and the bucket itself. The
+// volume metadata object is removed last, like in RemovePrefix.
+func (client *s3Client) RemoveBucket(ctx context.Context, bucketName string, opts RemoveOptions) error {
+	if err := client.removeVolume(ctx, bucketName, "", "", opts); err != nil {
+		return err
+	}
+	return client.minio.RemoveBucket(ctx, bucketName)
+}
+
+// removeVolume removes all objects of the volume or snapshot in
+// bucketName/prefix, then its metadata and objectName. The progress is saved
+// in RemovalMarkerKey, so that a removal which failed or was interrupted
+// resumes where it stopped. The metadata stays until the end, so the volume
+// or snapshot is still found for the next attempt.
+func (client *s3Client) removeVolume(ctx context.Context, bucketName, prefix, objectName string, opts RemoveOptions) error {
+	versions, err := client.versioned(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	metaKey := path.Join(prefix, VolumeMetaKey)
+	snapshotMetaKey := path.Join(prefix, SnapshotMetaKey)
+	markerKey := path.Join(prefix, RemovalMarkerKey)
+	var marker removalMarker
+	if _, err := client.getJSON(ctx, bucketName, markerKey, &marker); err != nil {
+		return err
+	}
+	if marker.LastKey != "" {
+		glog.V(4).Infof("Resuming removal of %s/%s after %s, %d objects were removed",
+			bucketName, prefix, marker.LastKey, marker.Removed)
+	}
+	progress := opts.Progress
+	lastCheckpoint := time.Now()
+	removeOpts := opts
+	removeOpts.Progress = nil
+	err = client.removeAll(ctx, &removal{
+		bucketName: bucketName,
+		prefix:     prefix,
+		keep: func(key string) bool {
+			return key == metaKey || key == snapshotMetaKey || key == markerKey
+		},
+		opts:       removeOpts,
+		versions:   versions,
+		startAfter: marker.LastKey,
+		checkpoint: func(lastKey string, removed int64) error {
+			removed += marker.Removed
+			if progress != nil {
+				progress(removed)
+			}
+			if time.Since(lastCheckpoint) < removalCheckpointInterval {
+				return nil
 			}
-			atomic.AddInt64(&totalObjects, 1)
-			objectsCh <- object
+			lastCheckpoint = time.Now()
+			return client.putJSON(ctx, bucketName, markerKey, &removalMarker{
+				LastKey:    lastKey,
+				Removed:    removed,
+				UpdateTime: lastCheckpoint,
+				TrashTime:  marker.TrashTime,
+			})
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// Objects written behind the marker meanwhile are found by the next
+	// attempt if removing the bucket fails
+	keys := []string{markerKey, snapshotMetaKey, metaKey}
+	if objectName != "" {
+		keys = append(keys, objectName)
+	}
+	for _, key := range keys {
+		if err := client.removeKey(ctx, bucketName, key, versions, opts); err != nil {
+			return err
 		}
-	}()
+	}
+	return nil
+}
 
-	if listErr != nil {
-		glog.Error("Error listing objects", listErr)
-		return listErr
+// MoveToTrash moves the volume in bucketName/prefix to
+// bucketName/.trash/<time of deletion>/prefix, from where PurgeTrash removes it.
+// enc is the encryption of the volume. The first attempt saves now as the
+// time of deletion in RemovalMarkerKey, a retried attempt resumes with it
+// instead of copying the volume to the trash again.
+func (client *s3Client) MoveToTrash(ctx context.Context, bucketName, prefix string, now time.Time, enc *Encryption, opts RemoveOptions) error {
+	markerKey := path.Join(prefix, RemovalMarkerKey)
+	var marker removalMarker
+	if _, err := client.getJSON(ctx, bucketName, markerKey, &marker); err != nil {
+		return err
+	}
+	if marker.TrashTime.IsZero() {
+		marker = removalMarker{UpdateTime: now, TrashTime: now.UTC()}
+		if err := client.putJSON(ctx, bucketName, markerKey, &marker); err != nil {
+			return err
+		}
+	} else {
+		glog.V(4).Infof("Resuming move of %s/%s to the trash of %s", bucketName, prefix, marker.TrashTime.Format(TrashTimeFormat))
+	}
+	trashPrefix := path.Join(TrashPrefix, marker.TrashTime.Format(TrashTimeFormat), prefix)
+	if _, err := client.copyObjects(ctx, bucketName, prefix, bucketName, trashPrefix, true, enc); err != nil {
+		return err
 	}
+	if prefix != "" {
+		return client.RemovePrefix(ctx, bucketName, prefix, opts)
+	}
+	// The bucket stays until its trash is purged
+	versions, err := client.versioned(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	keep := func(key string) bool {
+		return key == VolumeMetaKey || key == RemovalMarkerKey || strings.HasPrefix(key, TrashPrefix+"/")
+	}
+	if err := client.removeAll(ctx, &removal{bucketName: bucketName, keep: keep, opts: opts, versions: versions}); err != nil {
+		return err
+	}
+	for _, key := range []string{RemovalMarkerKey, VolumeMetaKey} {
+		if err := client.removeKey(ctx, bucketName, key, versions, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	for object := range objectsCh {
-		guardCh <- 1
-		go func(obj minio.ObjectInfo) {
-			err := client.minio.RemoveObject(client.ctx, bucketName, obj.Key,
-				minio.RemoveObjectOptions{VersionID: obj.VersionID})
+// PurgeTrash removes volumes moved to the trash of a bucket before the given
+// time. A bucket which was a volume itself is removed once it's empty.
+func (client *s3Client) PurgeTrash(ctx context.Context, bucketName string, before time.Time, opts RemoveOptions) error {
+	trashed, err := client.listPrefixes(ctx, bucketName, TrashPrefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, trashPrefix := range trashed {
+		deleted, err := time.Parse(TrashTimeFormat, path.Base(trashPrefix))
+		if err != nil || !deleted.Before(before) {
+			continue
+		}
+		meta, err := client.GetFSMeta(ctx, bucketName, trashPrefix)
+		if err != nil {
+			return err
+		}
+		glog.V(4).Infof("Purging trash %s/%s", bucketName, trashPrefix)
+		if err = client.RemovePrefix(ctx, bucketName, trashPrefix, opts); err != nil {
+			return err
+		}
+		if meta != nil && meta.Prefix == "" {
+			versions, err := client.versioned(ctx, bucketName)
 			if err != nil {
-				glog.Errorf("Failed to remove object %s, error: %s", obj.Key, err)
-				atomic.AddInt64(&removeErrors, 1)
+				return err
 			}
-			<-guardCh
-		}(object)
+			empty := true
+			for range client.minio.ListObjects(ctx, bucketName, minio.ListObjectsOptions{MaxKeys: 1, WithVersions: versions}) {
+				empty = false
+			}
+			if empty {
+				glog.V(4).Infof("Removing bucket %s of trashed volume", bucketName)
+				if err = client.minio.RemoveBucket(ctx, bucketName); err != nil {
+					return err
+				}
+			}
+		}
 	}
-	for i := 0; i < parallelism; i++ {
-		guardCh <- 1
+	return nil
+}
+
+// removal removes the objects in bucketName/prefix for which keep is false
+type removal struct {
+	bucketName string
+	prefix     string
+	keep       func(key string) bool
+	opts       RemoveOptions
+	// versions removes all versions and delete markers of the objects
+	versions bool
+	// startAfter is the last key removed by a previous removal
+	startAfter string
+	// checkpoint is called once all objects up to lastKey are removed, with
+	// the number of objects removed so far
+	checkpoint func(lastKey string, removed int64) error
+}
+
+// removeBatch is a batch of objects being removed, err is set when done is
+// closed
+type removeBatch struct {
+	objects []minio.ObjectInfo
+	done    chan struct{}
+	err     error
+}
+
+// removeAll removes objects in batches, several at once. Batches are listed
+// only as fast as they are removed, and checkpointed in the order of their
+// keys.
+func (client *s3Client) removeAll(ctx context.Context, r *removal) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listPrefix := r.prefix
+	if listPrefix != "" {
+		listPrefix += "/"
 	}
-	for i := 0; i < parallelism; i++ {
-		<-guardCh
+	objectsCh, listErr := client.listObjects(ctx, r.bucketName, listPrefix, r.startAfter, r.versions, r.keep)
+
+	batchSize := r.opts.batchSize()
+	batches := make(chan *removeBatch, r.opts.parallelism()-1)
+	go func() {
+		defer close(batches)
+		start := func(objects []minio.ObjectInfo) bool {
+			batch := &removeBatch{objects: objects, done: make(chan struct{})}
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return false
+			}
+			go func() {
+				defer close(batch.done)
+				batch.err = client.removeBatch(ctx, r.bucketName, batch.objects, r.opts)
+			}()
+			return true
+		}
+		objects := make([]minio.ObjectInfo, 0, batchSize)
+		for object := range objectsCh {
+			objects = append(objects, object)
+			if len(objects) == batchSize {
+				if !start(objects) {
+					return
+				}
+				objects = make([]minio.ObjectInfo, 0, batchSize)
+			}
+		}
+		if len(objects) > 0 {
+			start(objects)
+		}
+	}()
+
+	var removed int64
+	var err error
+	for batch := range batches {
+		<-batch.done
+		if err != nil {
+			continue
+		}
+		if batch.err != nil {
+			err = batch.err
+			cancel()
+			continue
+		}
+		removed += int64(len(batch.objects))
+		if r.checkpoint != nil {
+			if err = r.checkpoint(batch.objects[len(batch.objects)-1].Key, removed); err != nil {
+				cancel()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := <-listErr; err != nil {
+		glog.Error("Error listing objects", err)
+		return err
 	}
+	if r.opts.Progress != nil {
+		r.opts.Progress(removed)
+	}
+	return nil
+}
+
+// removeBatch removes objects with a single request, or one by one if the
+// endpoint doesn't support removing several objects at once. Objects which
+// can't be removed because of object lock fail it with an ObjectLockedError.
+func (client *s3Client) removeBatch(ctx context.Context, bucketName string, objects []minio.ObjectInfo, opts RemoveOptions) error {
+	objectsCh := make(chan minio.ObjectInfo, len(objects))
+	for _, object := range objects {
+		objectsCh <- object
+	}
+	close(objectsCh)
+
+	failed := make(map[string]bool)
+	var err error
+	for e := range client.minio.RemoveObjects(ctx, bucketName, objectsCh, minio.RemoveObjectsOptions{
+		GovernanceBypass: opts.GovernanceBypass,
+	}) {
+		glog.Errorf("Failed to remove object %s, error: %s", e.ObjectName, e.Err)
+		failed[e.ObjectName+"\x00"+e.VersionID] = true
+		err = e.Err
+	}
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	var remaining []minio.ObjectInfo
+	for _, object := range objects {
+		if failed[object.Key+"\x00"+object.VersionID] {
+			remaining = append(remaining, object)
+		}
+	}
+	if len(remaining) == 0 {
+		// The endpoint failed the whole request
+		remaining = objects
+	}
+	// Retrying locked objects is pointless
+	for i, object := range remaining {
+		if i == maxLockChecks {
+			break
+		}
+		if lockErr := client.objectLock(ctx, bucketName, object, opts.GovernanceBypass); lockErr != nil {
+			return lockErr
+		}
+	}
+	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+	return client.removeObjectsOneByOne(ctx, bucketName, remaining, opts)
+}
+
+// listObjects lists all objects in bucketName/prefix after startAfter for
+// which keep is false, or all their versions and delete markers with
+// versions. The listing stops when ctx is done, its error is sent once it
+// returns.
+func (client *s3Client) listObjects(ctx context.Context, bucketName, prefix, startAfter string, versions bool, keep func(key string) bool) (<-chan minio.ObjectInfo, <-chan error) {
+	if versions {
+		// Version listings can't start after a key, removed versions are
+		// not listed anymore anyway
+		startAfter = ""
+	}
+	objectsCh := make(chan minio.ObjectInfo)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(objectsCh)
+		for object := range client.minio.ListObjects(ctx, bucketName,
+			minio.ListObjectsOptions{Prefix: prefix, StartAfter: startAfter, WithVersions: versions, Recursive: true}) {
+			if object.Err != nil {
+				errCh <- object.Err
+				return
+			}
+			if keep(object.Key) {
+				continue
+			}
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			}
+		}
+		errCh <- ctx.Err()
+	}()
+	return objectsCh, errCh
+}
 
+// will delete files one by one without file lock
+func (client *s3Client) removeObjectsOneByOne(ctx context.Context, bucketName string, objects []minio.ObjectInfo, opts RemoveOptions) error {
+	var removeErrors int
+	var err error
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e := client.minio.RemoveObject(ctx, bucketName, obj.Key,
+			minio.RemoveObjectOptions{VersionID: obj.VersionID, GovernanceBypass: opts.GovernanceBypass}); e != nil {
+			glog.Errorf("Failed to remove object %s, error: %s", obj.Key, e)
+			removeErrors++
+			err = e
+		}
+	}
 	if removeErrors > 0 {
-		return fmt.Errorf("failed to remove %v objects out of total %v of path %s", removeErrors, totalObjects, bucketName)
+		return fmt.Errorf("failed to remove %v objects out of total %v of path %s: %w", removeErrors, len(objects), bucketName, err)
 	}
 
 	return nil
@@ -0,0 +1,208 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server  *httptest.Server
+		release chan struct{}
+	)
+
+	BeforeEach(func() {
+		// An endpoint which never answers
+		release = make(chan struct{})
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+	})
+
+	AfterEach(func() {
+		close(release)
+		server.Close()
+	})
+
+	It("gives up on a hung endpoint when the context is done", func() {
+		client, err := s3.NewClient(&s3.Config{Endpoint: server.URL, Region: "us-east-1"})
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		Expect(client.CreateBucket(ctx, "bucket", s3.BucketOptions{})).NotTo(Succeed())
+		Expect(client.RemovePrefix(ctx, "bucket", "prefix", s3.RemoveOptions{})).NotTo(Succeed())
+		Expect(client.RemoveBucket(ctx, "bucket", s3.RemoveOptions{})).NotTo(Succeed())
+		_, err = client.CopyPrefix(ctx, "bucket", "prefix", "bucket", "copy", nil)
+		Expect(err).To(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+})
+
+var _ = Describe("Volume lock", func() {
+	It("is only written over the lock which was read", func() {
+		client, err := s3.NewClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "FJDSJ",
+			"secretAccessKey": "DSG643HGDS",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		ctx := context.Background()
+		Expect(client.CreateBucket(ctx, "testlock", s3.BucketOptions{})).To(Succeed())
+		defer client.RemoveBucket(ctx, "testlock", s3.RemoveOptions{})
+
+		Expect(client.SetVolumeLock(ctx, "testlock", "pvc", &s3.VolumeLock{NodeID: "a"}, nil)).To(Succeed())
+		err = client.SetVolumeLock(ctx, "testlock", "pvc", &s3.VolumeLock{NodeID: "b"}, nil)
+		Expect(s3.ClassifyError(err)).To(Equal(s3.ErrPreconditionFailed))
+
+		lock, err := client.GetVolumeLock(ctx, "testlock", "pvc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.NodeID).To(Equal("a"))
+		Expect(client.SetVolumeLock(ctx, "testlock", "pvc", &s3.VolumeLock{NodeID: "a", RenewTime: time.Now()}, lock)).To(Succeed())
+		err = client.SetVolumeLock(ctx, "testlock", "pvc", &s3.VolumeLock{NodeID: "b"}, lock)
+		Expect(s3.ClassifyError(err)).To(Equal(s3.ErrPreconditionFailed))
+	})
+})
+
+var _ = Describe("Snapshot removal", func() {
+	It("keeps the snapshot metadata until everything else is removed", func() {
+		client, err := s3.NewClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "FJDSJ",
+			"secretAccessKey": "DSG643HGDS",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		ctx := context.Background()
+		Expect(client.CreateBucket(ctx, "testsnapremoval", s3.BucketOptions{})).To(Succeed())
+		defer client.RemoveBucket(ctx, "testsnapremoval", s3.RemoveOptions{})
+		Expect(client.SetSnapshotMeta(ctx, "testsnapremoval", "snap", &s3.SnapshotMeta{SourceVolumeID: "vol"})).To(Succeed())
+		mc, err := minio.New("127.0.0.1:9000", &minio.Options{Creds: credentials.NewStaticV4("FJDSJ", "DSG643HGDS", "")})
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			_, err := mc.PutObject(ctx, "testsnapremoval", fmt.Sprintf("snap/file%d", i),
+				strings.NewReader("data"), 4, minio.PutObjectOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		var found []bool
+		err = client.RemovePrefix(ctx, "testsnapremoval", "snap", s3.RemoveOptions{
+			BatchSize: 1,
+			Progress: func(int64) {
+				meta, err := client.GetSnapshotMeta(ctx, "testsnapremoval", "snap")
+				Expect(err).NotTo(HaveOccurred())
+				found = append(found, meta != nil)
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).NotTo(BeEmpty())
+		Expect(found).NotTo(ContainElement(false))
+		Expect(client.GetSnapshotMeta(ctx, "testsnapremoval", "snap")).To(BeNil())
+	})
+})
+
+var _ = Describe("Trash", func() {
+	It("resumes an interrupted move with the time of the first attempt", func() {
+		client, err := s3.NewClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "FJDSJ",
+			"secretAccessKey": "DSG643HGDS",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		ctx := context.Background()
+		Expect(client.CreateBucket(ctx, "testtrash", s3.BucketOptions{})).To(Succeed())
+		defer client.RemoveBucket(ctx, "testtrash", s3.RemoveOptions{})
+		Expect(client.SetFSMeta(ctx, &s3.FSMeta{BucketName: "testtrash", Prefix: "pvc"})).To(Succeed())
+		mc, err := minio.New("127.0.0.1:9000", &minio.Options{Creds: credentials.NewStaticV4("FJDSJ", "DSG643HGDS", "")})
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			_, err := mc.PutObject(ctx, "testtrash", fmt.Sprintf("pvc/file%d", i),
+				strings.NewReader("data"), 4, minio.PutObjectOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		interrupted, cancel := context.WithCancel(ctx)
+		err = client.MoveToTrash(interrupted, "testtrash", "pvc", first, nil, s3.RemoveOptions{
+			BatchSize: 1,
+			Progress:  func(int64) { cancel() },
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(client.MoveToTrash(ctx, "testtrash", "pvc", first.Add(time.Hour), nil, s3.RemoveOptions{})).To(Succeed())
+
+		var keys []string
+		for object := range mc.ListObjects(ctx, "testtrash", minio.ListObjectsOptions{Recursive: true}) {
+			Expect(object.Err).NotTo(HaveOccurred())
+			keys = append(keys, object.Key)
+		}
+		trash := s3.TrashPrefix + "/" + first.Format(s3.TrashTimeFormat) + "/pvc/"
+		Expect(keys).To(ConsistOf(trash+s3.VolumeMetaKey, trash+"file0", trash+"file1", trash+"file2"))
+	})
+})
+
+var _ = Describe("Prefix", func() {
+	It("exists once it has an object", func() {
+		client, err := s3.NewClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "FJDSJ",
+			"secretAccessKey": "DSG643HGDS",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		ctx := context.Background()
+		Expect(client.CreateBucket(ctx, "testprefix", s3.BucketOptions{})).To(Succeed())
+		defer client.RemoveBucket(ctx, "testprefix", s3.RemoveOptions{})
+
+		Expect(client.PrefixExists(ctx, "testprefix", "pvc")).To(BeFalse())
+		Expect(client.CreatePrefix(ctx, "testprefix", "pvc")).To(Succeed())
+		Expect(client.PrefixExists(ctx, "testprefix", "pvc")).To(BeTrue())
+		Expect(client.PrefixExists(ctx, "testprefix", "pv")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Listing", func() {
+	It("finds volumes and snapshots in buckets and their prefixes", func() {
+		client, err := s3.NewClientFromSecret(map[string]string{
+			"endpoint":        "http://127.0.0.1:9000",
+			"accessKeyID":     "FJDSJ",
+			"secretAccessKey": "DSG643HGDS",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		ctx := context.Background()
+		for _, bucket := range []string{"testlistvolume", "testlistprefixes", "testlistother"} {
+			Expect(client.CreateBucket(ctx, bucket, s3.BucketOptions{})).To(Succeed())
+			defer client.RemoveBucket(ctx, bucket, s3.RemoveOptions{})
+		}
+		Expect(client.SetFSMeta(ctx, &s3.FSMeta{BucketName: "testlistvolume", CapacityBytes: 10})).To(Succeed())
+		// Prefixes of a volume which is a whole bucket are its files
+		Expect(client.CreatePrefix(ctx, "testlistvolume", "dir")).To(Succeed())
+		Expect(client.SetFSMeta(ctx, &s3.FSMeta{BucketName: "testlistprefixes", Prefix: "pvc", CapacityBytes: 20})).To(Succeed())
+		Expect(client.SetSnapshotMeta(ctx, "testlistprefixes", "snap", &s3.SnapshotMeta{SourceVolumeID: "testlistprefixes/pvc"})).To(Succeed())
+		Expect(client.CreatePrefix(ctx, "testlistother", "dir")).To(Succeed())
+
+		volumes, err := client.ListVolumes(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(HaveKey("testlistvolume"))
+		Expect(volumes["testlistvolume"].CapacityBytes).To(BeEquivalentTo(10))
+		Expect(volumes).To(HaveKey("testlistprefixes/pvc"))
+		Expect(volumes["testlistprefixes/pvc"].CapacityBytes).To(BeEquivalentTo(20))
+		for id := range volumes {
+			Expect(id).NotTo(HavePrefix("testlistother"))
+			Expect(id).NotTo(Equal("testlistvolume/dir"))
+			Expect(id).NotTo(Equal("testlistprefixes/snap"))
+		}
+
+		snapshots, err := client.ListSnapshots(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshots).To(HaveKey("testlistprefixes/snap"))
+		Expect(snapshots).NotTo(HaveKey("testlistprefixes/pvc"))
+	})
+})
@@ -0,0 +1,231 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// credentialSource returns the provider of a source of credentials, or nil if
+// the source is not configured
+type credentialSource func(cfg *Config, client *http.Client) (credentials.Provider, error)
+
+// credentialSources are tried in order, the first one which is configured
+// provides the credentials. Unlike credentials.Chain, its errors are not
+// hidden by falling back to anonymous access.
+var credentialSources = []credentialSource{
+	staticCredentials,
+	webIdentityCredentials,
+	envCredentials,
+}
+
+// Credentials returns the current credentials configured in cfg, assuming
+// its role first if it has one
+func (cfg *Config) Credentials() (credentials.Value, error) {
+	transport, err := cfg.transport()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	creds, err := cfg.credentials(transport)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	return creds.Get()
+}
+
+func (cfg *Config) credentials(transport http.RoundTripper) (*credentials.Credentials, error) {
+	if cfg.Anonymous {
+		return credentials.NewStaticV4("", "", ""), nil
+	}
+	client := &http.Client{Transport: transport}
+	for _, source := range credentialSources {
+		provider, err := source(cfg, client)
+		if err != nil {
+			return nil, err
+		}
+		if provider != nil {
+			return credentials.New(provider), nil
+		}
+	}
+	// No credentials at all, requests are anonymous
+	return credentials.NewStaticV4("", "", ""), nil
+}
+
+// staticCredentials are the keys of the secret, used to assume its role if
+// it has one
+func staticCredentials(cfg *Config, client *http.Client) (credentials.Provider, error) {
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey == "" {
+		return nil, nil
+	}
+	if cfg.RoleARN == "" {
+		return &credentials.Static{Value: credentials.Value{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		}}, nil
+	}
+	stsEndpoint, err := cfg.stsEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	// The provider assumes the role again once the credentials expire
+	return &credentials.STSAssumeRole{
+		Client:      client,
+		STSEndpoint: stsEndpoint,
+		Options: credentials.STSAssumeRoleOptions{
+			AccessKey:       cfg.AccessKeyID,
+			SecretKey:       cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+			Location:        cfg.Region,
+			DurationSeconds: cfg.RoleDurationSeconds,
+			RoleARN:         cfg.RoleARN,
+			RoleSessionName: cfg.RoleSessionName,
+			ExternalID:      cfg.ExternalID,
+		},
+	}, nil
+}
+
+// AssumedRole is a role assumed with keys, for mounters which assume it
+// themselves to renew its credentials
+type AssumedRole struct {
+	RoleARN         string
+	SessionName     string
+	ExternalID      string
+	DurationSeconds int
+	STSEndpoint     string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AssumedRole returns the role cfg assumes with the keys of its secret, or
+// nil if it doesn't assume one so
+func (cfg *Config) AssumedRole() (*AssumedRole, error) {
+	if cfg.Anonymous || cfg.RoleARN == "" || (cfg.AccessKeyID == "" && cfg.SecretAccessKey == "") {
+		return nil, nil
+	}
+	stsEndpoint, err := cfg.stsEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return &AssumedRole{
+		RoleARN:         cfg.RoleARN,
+		SessionName:     cfg.RoleSessionName,
+		ExternalID:      cfg.ExternalID,
+		DurationSeconds: cfg.RoleDurationSeconds,
+		STSEndpoint:     stsEndpoint,
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+		SessionToken:    cfg.SessionToken,
+	}, nil
+}
+
+// WebIdentity is a role assumed with a web identity token, for mounters
+// which assume it themselves to renew its credentials
+type WebIdentity struct {
+	RoleARN     string
+	STSEndpoint string
+	// Token is the token itself, or empty if it is read from TokenFile
+	Token     string
+	TokenFile string
+}
+
+// WebIdentity returns the web identity cfg assumes its role with, or nil if
+// its credentials come from elsewhere. Both can also be set with the
+// environment variables used by the AWS SDKs.
+func (cfg *Config) WebIdentity() (*WebIdentity, error) {
+	if cfg.Anonymous || cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+		return nil, nil
+	}
+	tokenFile := cfg.WebIdentityTokenFile
+	if tokenFile == "" && cfg.WebIdentityToken == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if tokenFile == "" && cfg.WebIdentityToken == "" {
+		return nil, nil
+	}
+	roleARN := cfg.RoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	stsEndpoint, err := cfg.stsEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WebIdentityToken != "" {
+		tokenFile = ""
+	}
+	return &WebIdentity{
+		RoleARN:     roleARN,
+		STSEndpoint: stsEndpoint,
+		Token:       cfg.WebIdentityToken,
+		TokenFile:   tokenFile,
+	}, nil
+}
+
+// ReadToken returns the current token, read again from its file every time
+// as the token is rotated
+func (id *WebIdentity) ReadToken() (string, error) {
+	if id.Token != "" {
+		return id.Token, nil
+	}
+	token, err := os.ReadFile(id.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read web identity token: %v", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// webIdentityCredentials exchange a token, like a projected service account
+// token, for credentials of a role
+func webIdentityCredentials(cfg *Config, client *http.Client) (credentials.Provider, error) {
+	id, err := cfg.WebIdentity()
+	if err != nil || id == nil {
+		return nil, err
+	}
+	return &credentials.STSWebIdentity{
+		Client:      client,
+		STSEndpoint: id.STSEndpoint,
+		RoleARN:     id.RoleARN,
+		GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+			token, err := id.ReadToken()
+			if err != nil {
+				return nil, err
+			}
+			return &credentials.WebIdentityToken{
+				Token:  token,
+				Expiry: cfg.RoleDurationSeconds,
+			}, nil
+		},
+	}, nil
+}
+
+// envCredentials are the keys in the environment of the driver
+func envCredentials(_ *Config, _ *http.Client) (credentials.Provider, error) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_ACCESS_KEY") == "" {
+		return nil, nil
+	}
+	return &credentials.EnvAWS{}, nil
+}
+
+func (cfg *Config) stsEndpoint() (string, error) {
+	if cfg.STSEndpoint != "" {
+		return cfg.STSEndpoint, nil
+	}
+	u, err := ParseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(u.Hostname(), ".amazonaws.com") {
+		// MinIO and most other implementations serve STS on the S3 endpoint
+		return u.String(), nil
+	}
+	if cfg.Region == "" {
+		return "https://sts.amazonaws.com", nil
+	}
+	return fmt.Sprintf("https://sts.%s.amazonaws.com", cfg.Region), nil
+}
@@ -0,0 +1,189 @@
+package s3_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const stsResponse = `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>%[2]s-secret</SecretAccessKey>
+      <SessionToken>%[2]s-token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`
+
+// newSTS returns a stand-in for STS, issuing keys named after the role and,
+// for web identities, the token
+func newSTS() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		action := r.Form.Get("Action")
+		key := r.Form.Get("RoleArn")
+		switch action {
+		case "AssumeRole":
+			if r.Header.Get("Authorization") == "" {
+				http.Error(w, "unsigned request", http.StatusForbidden)
+				return
+			}
+		case "AssumeRoleWithWebIdentity":
+			key += "/" + r.Form.Get("WebIdentityToken")
+		default:
+			http.Error(w, "unknown action "+action, http.StatusBadRequest)
+			return
+		}
+		expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, stsResponse, action, key, expiration)
+	}))
+}
+
+// awsEnvs are the environment variables read by the credential sources
+var awsEnvs = []string{"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
+
+var _ = Describe("Credentials", func() {
+	var (
+		sts       *httptest.Server
+		tokenDir  string
+		tokenFile string
+	)
+
+	BeforeEach(func() {
+		sts = newSTS()
+		var err error
+		tokenDir, err = os.MkdirTemp("", "credentials")
+		Expect(err).NotTo(HaveOccurred())
+		tokenFile = filepath.Join(tokenDir, "token")
+		Expect(os.WriteFile(tokenFile, []byte("jwt\n"), 0600)).To(Succeed())
+		for _, env := range awsEnvs {
+			os.Unsetenv(env)
+		}
+	})
+
+	AfterEach(func() {
+		sts.Close()
+		Expect(os.RemoveAll(tokenDir)).To(Succeed())
+		for _, env := range awsEnvs {
+			os.Unsetenv(env)
+		}
+	})
+
+	It("uses the static keys of the secret", func() {
+		cfg := &s3.Config{AccessKeyID: "key", SecretAccessKey: "secret", SessionToken: "token", Endpoint: sts.URL}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("key"))
+		Expect(creds.SecretAccessKey).To(Equal("secret"))
+		Expect(creds.SessionToken).To(Equal("token"))
+	})
+
+	It("assumes the role of the secret with its keys", func() {
+		cfg := &s3.Config{AccessKeyID: "key", SecretAccessKey: "secret", RoleARN: "role", STSEndpoint: sts.URL, Endpoint: "https://s3.amazonaws.com"}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("role"))
+		Expect(creds.SessionToken).To(Equal("role-token"))
+	})
+
+	It("describes the role assumed with the keys of the secret", func() {
+		cfg := &s3.Config{AccessKeyID: "key", SecretAccessKey: "secret", RoleARN: "role", ExternalID: "id", Region: "eu-west-1", Endpoint: "https://s3.amazonaws.com"}
+		role, err := cfg.AssumedRole()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role).To(Equal(&s3.AssumedRole{
+			RoleARN:         "role",
+			ExternalID:      "id",
+			STSEndpoint:     "https://sts.eu-west-1.amazonaws.com",
+			AccessKeyID:     "key",
+			SecretAccessKey: "secret",
+		}))
+		cfg.RoleARN = ""
+		Expect(cfg.AssumedRole()).To(BeNil())
+	})
+
+	It("exchanges the web identity token of the secret", func() {
+		cfg := &s3.Config{WebIdentityTokenFile: tokenFile, RoleARN: "role", Endpoint: sts.URL}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("role/jwt"))
+		Expect(creds.SecretAccessKey).To(Equal("role/jwt-secret"))
+		Expect(creds.SessionToken).To(Equal("role/jwt-token"))
+	})
+
+	It("exchanges the web identity token of the environment", func() {
+		os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+		os.Setenv("AWS_ROLE_ARN", "env-role")
+		cfg := &s3.Config{Endpoint: sts.URL}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("env-role/jwt"))
+	})
+
+	It("describes the web identity of the environment", func() {
+		os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+		os.Setenv("AWS_ROLE_ARN", "env-role")
+		cfg := &s3.Config{Endpoint: sts.URL}
+		id, err := cfg.WebIdentity()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).To(Equal(&s3.WebIdentity{RoleARN: "env-role", STSEndpoint: sts.URL, TokenFile: tokenFile}))
+		Expect(id.ReadToken()).To(Equal("jwt"))
+		cfg.AccessKeyID = "key"
+		Expect(cfg.WebIdentity()).To(BeNil())
+	})
+
+	It("fails if the web identity token can't be read", func() {
+		cfg := &s3.Config{WebIdentityTokenFile: tokenFile + "-missing", RoleARN: "role", Endpoint: sts.URL}
+		_, err := cfg.Credentials()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("uses the keys of the environment", func() {
+		os.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+		os.Setenv("AWS_SESSION_TOKEN", "env-token")
+		cfg := &s3.Config{Endpoint: sts.URL}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("env-key"))
+		Expect(creds.SessionToken).To(Equal("env-token"))
+	})
+
+	It("prefers the secret to the environment", func() {
+		os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+		os.Setenv("AWS_ROLE_ARN", "env-role")
+		os.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+		cfg := &s3.Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: sts.URL}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("key"))
+	})
+
+	It("is anonymous without any credentials", func() {
+		cfg := &s3.Config{Endpoint: sts.URL}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(BeEmpty())
+	})
+
+	It("is anonymous when requested, whatever credentials there are", func() {
+		os.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+		cfg := &s3.Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: sts.URL, Anonymous: true}
+		creds, err := cfg.Credentials()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(BeEmpty())
+	})
+})
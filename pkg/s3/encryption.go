@@ -0,0 +1,104 @@
+package s3
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"path"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/sse"
+)
+
+const (
+	// EncryptionKey selects the server-side encryption of the objects of a
+	// volume in its parameters
+	EncryptionKey    = "encryption"
+	EncryptionSSES3  = "sse-s3"
+	EncryptionSSEKMS = "sse-kms"
+	EncryptionSSEC   = "sse-c"
+	// SSEKMSKeyIDKey is the KMS key of EncryptionSSEKMS. The key of the
+	// secret is used if it's not set, the default key of the account if
+	// neither is.
+	SSEKMSKeyIDKey = "sseKMSKeyID"
+)
+
+// Encryption is the server-side encryption of the objects of a volume
+type Encryption struct {
+	Mode     string
+	KMSKeyID string
+	// CustomerKey is the 256 bit key of EncryptionSSEC
+	CustomerKey []byte
+}
+
+// VolumeEncryption returns the encryption selected in the parameters of a
+// volume, with the keys of cfg, or nil if it has none
+func VolumeEncryption(params map[string]string, cfg *Config) (*Encryption, error) {
+	switch mode := params[EncryptionKey]; mode {
+	case "":
+		return nil, nil
+	case EncryptionSSES3:
+		return &Encryption{Mode: mode}, nil
+	case EncryptionSSEKMS:
+		return &Encryption{Mode: mode, KMSKeyID: cmp.Or(params[SSEKMSKeyIDKey], cfg.SSEKMSKeyID)}, nil
+	case EncryptionSSEC:
+		if cfg.SSECustomerKey == "" {
+			return nil, fmt.Errorf("%s=%s requires sseCustomerKey in the secret", EncryptionKey, mode)
+		}
+		key, err := base64.StdEncoding.DecodeString(cfg.SSECustomerKey)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("sseCustomerKey must be a base64 encoded 256 bit key")
+		}
+		return &Encryption{Mode: mode, CustomerKey: key}, nil
+	default:
+		return nil, fmt.Errorf("%s must be empty, %s, %s or %s",
+			EncryptionKey, EncryptionSSES3, EncryptionSSEKMS, EncryptionSSEC)
+	}
+}
+
+// CustomerKeyBase64 returns the key of EncryptionSSEC as mounters take it
+func (e *Encryption) CustomerKeyBase64() string {
+	return base64.StdEncoding.EncodeToString(e.CustomerKey)
+}
+
+// serverSide returns the headers encrypting objects written by the driver,
+// nil if there is no encryption
+func (e *Encryption) serverSide() (encrypt.ServerSide, error) {
+	if e == nil {
+		return nil, nil
+	}
+	switch e.Mode {
+	case EncryptionSSES3:
+		return encrypt.NewSSE(), nil
+	case EncryptionSSEKMS:
+		return encrypt.NewSSEKMS(e.KMSKeyID, nil)
+	case EncryptionSSEC:
+		return encrypt.NewSSEC(e.CustomerKey)
+	}
+	return nil, nil
+}
+
+// bucketConfig returns the default encryption of a bucket, nil for
+// EncryptionSSEC which can't be a default
+func (e *Encryption) bucketConfig() *sse.Configuration {
+	if e == nil {
+		return nil
+	}
+	switch e.Mode {
+	case EncryptionSSES3:
+		return sse.NewConfigurationSSES3()
+	case EncryptionSSEKMS:
+		return sse.NewConfigurationSSEKMS(e.KMSKeyID)
+	}
+	return nil
+}
+
+// driverObject tells whether key is an object written by the driver itself,
+// which is never encrypted with a customer key
+func driverObject(key string) bool {
+	switch path.Base(key) {
+	case VolumeMetaKey, SnapshotMetaKey, VolumeLockKey, RemovalMarkerKey:
+		return true
+	}
+	return false
+}
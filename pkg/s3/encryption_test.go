@@ -0,0 +1,56 @@
+package s3_test
+
+import (
+	"encoding/base64"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VolumeEncryption", func() {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	It("returns nothing without encryption", func() {
+		enc, err := s3.VolumeEncryption(map[string]string{}, &s3.Config{SSECustomerKey: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc).To(BeNil())
+	})
+
+	It("uses SSE-S3", func() {
+		enc, err := s3.VolumeEncryption(map[string]string{"encryption": "sse-s3"}, &s3.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc.Mode).To(Equal(s3.EncryptionSSES3))
+	})
+
+	It("prefers the KMS key of the parameters over the one of the secret", func() {
+		cfg := &s3.Config{SSEKMSKeyID: "secret-key"}
+		enc, err := s3.VolumeEncryption(map[string]string{"encryption": "sse-kms"}, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc.KMSKeyID).To(Equal("secret-key"))
+		enc, err = s3.VolumeEncryption(map[string]string{"encryption": "sse-kms", "sseKMSKeyID": "class-key"}, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc.KMSKeyID).To(Equal("class-key"))
+	})
+
+	It("takes the customer key from the secret", func() {
+		enc, err := s3.VolumeEncryption(map[string]string{"encryption": "sse-c"}, &s3.Config{SSECustomerKey: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc.CustomerKey).To(HaveLen(32))
+		Expect(enc.CustomerKeyBase64()).To(Equal(key))
+	})
+
+	It("rejects a missing or invalid customer key", func() {
+		_, err := s3.VolumeEncryption(map[string]string{"encryption": "sse-c"}, &s3.Config{})
+		Expect(err).To(HaveOccurred())
+		_, err = s3.VolumeEncryption(map[string]string{"encryption": "sse-c"}, &s3.Config{
+			SSECustomerKey: base64.StdEncoding.EncodeToString([]byte("short")),
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects unknown modes", func() {
+		_, err := s3.VolumeEncryption(map[string]string{"encryption": "aes"}, &s3.Config{})
+		Expect(err).To(HaveOccurred())
+	})
+})
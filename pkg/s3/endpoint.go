@@ -0,0 +1,146 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+// Addressing styles of buckets, in the addressingStyle key of the secret
+const (
+	// AddressingPath puts the bucket in the path: https://host/bucket/key
+	AddressingPath = "path"
+	// AddressingVirtual puts it in the host name: https://bucket.host/key
+	AddressingVirtual = "virtual"
+	// AddressingAuto uses virtual hosts for AWS, Google Cloud Storage and
+	// Aliyun OSS and paths for everything else, like the MinIO client
+	AddressingAuto = "auto"
+)
+
+// credentialScope finds the region in the Authorization header of a request
+// signed with signature version 4
+var credentialScope = regexp.MustCompile(`Credential=[^/]*/[^/]*/([^/]*)/`)
+
+// ParseEndpoint parses the URL of an S3 endpoint. It defaults to https without
+// a scheme and may have a path, which is then prepended to the path of every
+// request, e.g. for S3 behind a gateway at https://gw.example/s3.
+func ParseEndpoint(endpoint string) (*url.URL, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("endpoint is empty")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", endpoint)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: no host", endpoint)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid endpoint %q: only a scheme, host, port and path are allowed", endpoint)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// EndpointURL returns the normalized URL of the endpoint, as passed to the
+// mounters
+func (cfg *Config) EndpointURL() (string, error) {
+	u, err := ParseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// checkAddressingStyle returns an error for unknown addressing styles
+func checkAddressingStyle(style string) error {
+	switch style {
+	case "", AddressingPath, AddressingVirtual, AddressingAuto:
+		return nil
+	default:
+		return fmt.Errorf("addressingStyle must be %s, %s or %s, got %q",
+			AddressingPath, AddressingVirtual, AddressingAuto, style)
+	}
+}
+
+// bucketLookup returns how the MinIO client addresses buckets
+func (cfg *Config) bucketLookup() minio.BucketLookupType {
+	switch cfg.AddressingStyle {
+	case AddressingPath:
+		return minio.BucketLookupPath
+	case AddressingVirtual:
+		return minio.BucketLookupDNS
+	default:
+		return minio.BucketLookupAuto
+	}
+}
+
+// PathStyle tells whether bucketName is addressed with the path style, so
+// mounters address buckets like the driver does
+func (cfg *Config) PathStyle(bucketName string) (bool, error) {
+	switch cfg.AddressingStyle {
+	case AddressingPath:
+		return true, nil
+	case AddressingVirtual:
+		return false, nil
+	}
+	u, err := ParseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	// The MinIO client decides without the path
+	u.Path = ""
+	return !s3utils.IsVirtualHostSupported(*u, bucketName), nil
+}
+
+// requestTransport prepends a path and adds headers to the requests of the
+// MinIO client, which supports neither endpoints with a path nor headers
+// common to all requests, and signs them again. It is only used over https,
+// where the MinIO client doesn't sign uploads chunk by chunk.
+type requestTransport struct {
+	base   http.RoundTripper
+	prefix string
+	header http.Header
+	creds  func() (accessKeyID, secretAccessKey, sessionToken string, err error)
+}
+
+func (t *requestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Path = t.prefix + req.URL.Path
+	req.URL.RawPath = ""
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		// Anonymous
+		return t.base.RoundTrip(req)
+	}
+	if strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-AWS4-") {
+		// Chunks are signed with the signature of the request
+		return nil, fmt.Errorf("streaming signatures can't be signed again")
+	}
+	match := credentialScope.FindStringSubmatch(auth)
+	if match == nil {
+		return nil, fmt.Errorf("only signature version 4 can be signed again")
+	}
+	accessKeyID, secretAccessKey, sessionToken, err := t.creds()
+	if err != nil {
+		return nil, err
+	}
+	req = signer.SignV4Trailer(*req, accessKeyID, secretAccessKey, sessionToken, match[1], req.Trailer)
+	return t.base.RoundTrip(req)
+}
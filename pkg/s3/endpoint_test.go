@@ -0,0 +1,81 @@
+package s3_test
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("ParseEndpoint",
+	func(endpoint, expected string) {
+		u, err := s3.ParseEndpoint(endpoint)
+		if expected == "" {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(u.String()).To(Equal(expected))
+	},
+	Entry("host", "https://s3.example", "https://s3.example"),
+	Entry("without a scheme", "s3.example:9000", "https://s3.example:9000"),
+	Entry("path", "https://gw.example/s3/", "https://gw.example/s3"),
+	Entry("IPv6 literal", "http://[fd00::1]:9000", "http://[fd00::1]:9000"),
+	Entry("empty", "", ""),
+	Entry("other scheme", "ftp://s3.example", ""),
+	Entry("query", "https://s3.example/?a=b", ""),
+)
+
+var _ = DescribeTable("PathStyle",
+	func(endpoint, style, bucket string, expected bool) {
+		cfg := &s3.Config{Endpoint: endpoint, AddressingStyle: style}
+		Expect(cfg.PathStyle(bucket)).To(Equal(expected))
+	},
+	Entry("auto for AWS", "https://s3.eu-west-1.amazonaws.com", "", "bucket", false),
+	Entry("auto for AWS with a dotted bucket", "https://s3.eu-west-1.amazonaws.com", s3.AddressingAuto, "my.bucket", true),
+	Entry("auto for others", "https://minio.example", "", "bucket", true),
+	Entry("path", "https://s3.eu-west-1.amazonaws.com", s3.AddressingPath, "bucket", true),
+	Entry("virtual", "https://minio.example", s3.AddressingVirtual, "bucket", false),
+)
+
+var _ = Describe("Endpoint path", func() {
+	It("is prepended to requests", func() {
+		var paths []string
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			if r.URL.Query().Has("location") {
+				fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+			}
+		}))
+		defer server.Close()
+		client, err := s3.NewClient(&s3.Config{
+			Endpoint:        server.URL + "/s3",
+			AccessKeyID:     "key",
+			SecretAccessKey: "secret",
+			CABundle:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.BucketExists(context.Background(), "bucket")).To(BeTrue())
+		Expect(paths).To(ConsistOf("/s3/bucket/", "/s3/bucket/"))
+	})
+
+	It("requires https, as uploads over http can't be signed again", func() {
+		_, err := s3.NewClient(&s3.Config{Endpoint: "http://gw.example/s3", AccessKeyID: "key", SecretAccessKey: "secret"})
+		Expect(err).To(HaveOccurred())
+		_, err = s3.NewClient(&s3.Config{Endpoint: "http://s3.example", AccessKeyID: "key", SecretAccessKey: "secret", RequesterPays: true})
+		Expect(err).To(HaveOccurred())
+		_, err = s3.NewClient(&s3.Config{Endpoint: "http://gw.example/s3", Anonymous: true})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects unknown addressing styles", func() {
+		_, err := s3.ConfigFromSecret(map[string]string{"endpoint": "https://s3.example", "addressingStyle": "dns"})
+		Expect(err).To(HaveOccurred())
+	})
+})
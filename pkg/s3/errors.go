@@ -0,0 +1,159 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrorKind classifies errors of S3 requests by what a caller can do about
+// them
+type ErrorKind int
+
+const (
+	// ErrUnknown is any error not classified below
+	ErrUnknown ErrorKind = iota
+	// ErrNotFound is a missing bucket, object or upload
+	ErrNotFound
+	// ErrPermissionDenied is a request the credentials don't allow, or
+	// credentials which are not valid at all
+	ErrPermissionDenied
+	// ErrUnavailable is an overloaded or unreachable endpoint, worth retrying
+	ErrUnavailable
+	// ErrAlreadyOwned is a bucket which exists and belongs to the caller
+	ErrAlreadyOwned
+	// ErrAlreadyExists is a bucket name taken by someone else
+	ErrAlreadyExists
+	// ErrInvalidArgument is a request the endpoint rejects as malformed
+	ErrInvalidArgument
+	// ErrQuotaExceeded is a limit of the account, like its number of buckets
+	ErrQuotaExceeded
+	// ErrNotEmpty is a bucket which can't be removed as it still has objects
+	ErrNotEmpty
+	// ErrCanceled and ErrDeadlineExceeded are requests whose context is done
+	ErrCanceled
+	ErrDeadlineExceeded
+	// ErrObjectLocked is an object version protected by object lock
+	ErrObjectLocked
+	// ErrBucketMismatch is an existing bucket without the requested settings
+	ErrBucketMismatch
+	// ErrPreconditionFailed is a conditional write of an object which was
+	// changed meanwhile
+	ErrPreconditionFailed
+)
+
+var errorKinds = map[string]ErrorKind{
+	"NoSuchBucket":                         ErrNotFound,
+	"NoSuchKey":                            ErrNotFound,
+	"NoSuchUpload":                         ErrNotFound,
+	"NoSuchVersion":                        ErrNotFound,
+	"XMinioAdminNoSuchBucket":              ErrNotFound,
+	"NoSuchTagSet":                         ErrNotFound,
+	"ObjectLockConfigurationNotFoundError": ErrNotFound,
+	"ServerSideEncryptionConfigurationNotFoundError": ErrNotFound,
+	"AccessDenied":                   ErrPermissionDenied,
+	"AllAccessDisabled":              ErrPermissionDenied,
+	"InvalidAccessKeyId":             ErrPermissionDenied,
+	"SignatureDoesNotMatch":          ErrPermissionDenied,
+	"ExpiredToken":                   ErrPermissionDenied,
+	"InvalidToken":                   ErrPermissionDenied,
+	"AccountProblem":                 ErrPermissionDenied,
+	"SlowDown":                       ErrUnavailable,
+	"SlowDownRead":                   ErrUnavailable,
+	"SlowDownWrite":                  ErrUnavailable,
+	"ServiceUnavailable":             ErrUnavailable,
+	"InternalError":                  ErrUnavailable,
+	"RequestTimeout":                 ErrUnavailable,
+	"XMinioServerNotInitialized":     ErrUnavailable,
+	"BucketAlreadyOwnedByYou":        ErrAlreadyOwned,
+	"BucketAlreadyExists":            ErrAlreadyExists,
+	"InvalidBucketName":              ErrInvalidArgument,
+	"InvalidArgument":                ErrInvalidArgument,
+	"InvalidRequest":                 ErrInvalidArgument,
+	"KeyTooLongError":                ErrInvalidArgument,
+	"TooManyBuckets":                 ErrQuotaExceeded,
+	"QuotaExceeded":                  ErrQuotaExceeded,
+	"XMinioAdminBucketQuotaExceeded": ErrQuotaExceeded,
+	"BucketNotEmpty":                 ErrNotEmpty,
+	"PreconditionFailed":             ErrPreconditionFailed,
+	"ConditionalRequestConflict":     ErrPreconditionFailed,
+}
+
+// ClassifyError returns the kind of an error of an S3 request, wrapped or not
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return ErrUnknown
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrDeadlineExceeded
+	}
+	var locked *ObjectLockedError
+	if errors.As(err, &locked) {
+		return ErrObjectLocked
+	}
+	var mismatch *BucketMismatchError
+	if errors.As(err, &mismatch) {
+		return ErrBucketMismatch
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		if kind, ok := errorKinds[resp.Code]; ok {
+			return kind
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrNotFound
+		case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+			return ErrPermissionDenied
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+			return ErrUnavailable
+		case resp.StatusCode == http.StatusBadRequest:
+			return ErrInvalidArgument
+		}
+		return ErrUnknown
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrUnavailable
+	}
+	return ErrUnknown
+}
+
+// IsNotFound tells whether err is about a missing bucket or object
+func IsNotFound(err error) bool {
+	return ClassifyError(err) == ErrNotFound
+}
+
+// ObjectLockedError is an object version which can't be removed because of
+// its legal hold or its retention
+type ObjectLockedError struct {
+	Bucket    string
+	Key       string
+	VersionID string
+	LegalHold bool
+	// RetentionMode is set if the version is retained until RetainUntil
+	RetentionMode minio.RetentionMode
+	RetainUntil   time.Time
+}
+
+func (e *ObjectLockedError) Error() string {
+	object := fmt.Sprintf("object %s/%s version %s", e.Bucket, e.Key, e.VersionID)
+	if e.LegalHold {
+		return object + " is under a legal hold, it must be released first"
+	}
+	msg := fmt.Sprintf("%s is retained in %s mode until %s", object,
+		strings.ToLower(string(e.RetentionMode)), e.RetainUntil.Format(time.RFC3339))
+	if e.RetentionMode == minio.Governance {
+		msg += ", removing it requires bypassing governance retention"
+	}
+	return msg
+}
@@ -0,0 +1,41 @@
+package s3_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	"github.com/minio/minio-go/v7"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("ClassifyError",
+	func(err error, kind s3.ErrorKind) {
+		Expect(s3.ClassifyError(err)).To(Equal(kind))
+	},
+	Entry("no such bucket", minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: 404}, s3.ErrNotFound),
+	Entry("no such key", minio.ErrorResponse{Code: "NoSuchKey", StatusCode: 404}, s3.ErrNotFound),
+	Entry("not found without a code", minio.ErrorResponse{StatusCode: 404}, s3.ErrNotFound),
+	Entry("access denied", minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403}, s3.ErrPermissionDenied),
+	Entry("invalid key", minio.ErrorResponse{Code: "InvalidAccessKeyId", StatusCode: 403}, s3.ErrPermissionDenied),
+	Entry("slow down", minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}, s3.ErrUnavailable),
+	Entry("service unavailable", minio.ErrorResponse{StatusCode: 503}, s3.ErrUnavailable),
+	Entry("owned bucket", minio.ErrorResponse{Code: "BucketAlreadyOwnedByYou", StatusCode: 409}, s3.ErrAlreadyOwned),
+	Entry("taken bucket", minio.ErrorResponse{Code: "BucketAlreadyExists", StatusCode: 409}, s3.ErrAlreadyExists),
+	Entry("invalid bucket name", minio.ErrorResponse{Code: "InvalidBucketName", StatusCode: 400}, s3.ErrInvalidArgument),
+	Entry("too many buckets", minio.ErrorResponse{Code: "TooManyBuckets", StatusCode: 400}, s3.ErrQuotaExceeded),
+	Entry("bucket not empty", minio.ErrorResponse{Code: "BucketNotEmpty", StatusCode: 409}, s3.ErrNotEmpty),
+	Entry("precondition failed", minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: 412}, s3.ErrPreconditionFailed),
+	Entry("wrapped", fmt.Errorf("failed: %w", minio.ErrorResponse{Code: "NoSuchBucket"}), s3.ErrNotFound),
+	Entry("canceled", fmt.Errorf("failed: %w", context.Canceled), s3.ErrCanceled),
+	Entry("deadline", context.DeadlineExceeded, s3.ErrDeadlineExceeded),
+	Entry("object lock", fmt.Errorf("failed: %w", &s3.ObjectLockedError{LegalHold: true}), s3.ErrObjectLocked),
+	Entry("bucket mismatch", &s3.BucketMismatchError{Bucket: "b"}, s3.ErrBucketMismatch),
+	Entry("network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, s3.ErrUnavailable),
+	Entry("unknown code", minio.ErrorResponse{Code: "Whatever", StatusCode: 409}, s3.ErrUnknown),
+	Entry("other", errors.New("other"), s3.ErrUnknown),
+	Entry("nil", nil, s3.ErrUnknown),
+)
@@ -0,0 +1,8 @@
+package s3
+
+// Len returns the number of cached clients
+func (c *ClientCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.clients)
+}
@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig holds the proxies of requests to S3, with the syntax of the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+type ProxyConfig struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// IsZero tells whether no proxy is configured
+func (p ProxyConfig) IsZero() bool {
+	return p == ProxyConfig{}
+}
+
+// proxyFunc returns the Proxy function of a transport, nil to connect
+// directly
+func (p ProxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if p.HTTPProxy == "" && p.HTTPSProxy == "" {
+		return nil
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  p.HTTPProxy,
+		HTTPSProxy: p.HTTPSProxy,
+		NoProxy:    p.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// Env returns the environment variables passing the proxies to a mounter.
+// Both cases are set, as curl only reads http_proxy in lower case.
+func (p ProxyConfig) Env() []string {
+	var envs []string
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", p.HTTPProxy},
+		{"http_proxy", p.HTTPProxy},
+		{"HTTPS_PROXY", p.HTTPSProxy},
+		{"https_proxy", p.HTTPSProxy},
+		{"NO_PROXY", p.NoProxy},
+		{"no_proxy", p.NoProxy},
+	} {
+		if v.value != "" {
+			envs = append(envs, v.name+"="+v.value)
+		}
+	}
+	return envs
+}
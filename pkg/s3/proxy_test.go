@@ -0,0 +1,62 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Proxy", func() {
+	var (
+		proxy *httptest.Server
+		hosts []string
+	)
+
+	BeforeEach(func() {
+		hosts = nil
+		// Every bucket exists, in the default region
+		proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hosts = append(hosts, r.Host)
+			if r.URL.Query().Has("location") {
+				fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		proxy.Close()
+	})
+
+	It("sends requests through the proxy", func() {
+		client, err := s3.NewClient(&s3.Config{
+			Endpoint: "http://s3.example",
+			Proxy:    s3.ProxyConfig{HTTPProxy: proxy.URL},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.BucketExists(context.Background(), "bucket")).To(BeTrue())
+		Expect(hosts).NotTo(BeEmpty())
+		Expect(hosts).To(HaveEach("s3.example"))
+	})
+
+	It("uses the default proxy of the cache for secrets without one", func() {
+		cache := s3.NewClientCache(time.Minute, s3.ProxyConfig{HTTPProxy: proxy.URL})
+		client, err := cache.ClientFromSecret(map[string]string{"endpoint": "http://s3.example"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.Config.Proxy.HTTPProxy).To(Equal(proxy.URL))
+		Expect(client.BucketExists(context.Background(), "bucket")).To(BeTrue())
+	})
+
+	It("passes the proxies to the mounters in both cases", func() {
+		p := s3.ProxyConfig{HTTPSProxy: "http://proxy:3128", NoProxy: ".svc"}
+		Expect(p.Env()).To(ConsistOf(
+			"HTTPS_PROXY=http://proxy:3128", "https_proxy=http://proxy:3128",
+			"NO_PROXY=.svc", "no_proxy=.svc",
+		))
+	})
+})
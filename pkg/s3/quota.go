@@ -0,0 +1,77 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+// minioQuota is the body of a MinIO set-bucket-quota request
+type minioQuota struct {
+	Quota uint64 `json:"quota"`
+	Size  uint64 `json:"size"`
+	Type  string `json:"quotatype"`
+}
+
+// SetMinioBucketQuota sets a hard quota on a bucket through the MinIO admin
+// API. Other S3 implementations have no equivalent, so this fails on them.
+func (client *s3Client) SetMinioBucketQuota(ctx context.Context, bucketName string, sizeBytes int64) error {
+	if sizeBytes < 0 {
+		return fmt.Errorf("invalid quota %d", sizeBytes)
+	}
+	data, err := json.Marshal(&minioQuota{
+		Quota: uint64(sizeBytes),
+		Size:  uint64(sizeBytes),
+		Type:  "hard",
+	})
+	if err != nil {
+		return err
+	}
+
+	u := *client.minio.EndpointURL()
+	u.Path = client.basePath + "/minio/admin/v3/set-bucket-quota"
+	u.RawQuery = url.Values{"bucket": {bucketName}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	sum := sha256.Sum256(data)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	region := client.Config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	creds, err := client.creds.Get()
+	if err != nil {
+		return err
+	}
+	req = signer.SignV4(*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, region)
+
+	resp, err := (&http.Client{Transport: client.transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The admin API answers with the fields of an S3 error in JSON
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		errResp := minio.ErrorResponse{}
+		if json.Unmarshal(body, &errResp) != nil || errResp.Message == "" {
+			errResp.Message = fmt.Sprintf("%s: %s", resp.Status, bytes.TrimSpace(body))
+		}
+		errResp.StatusCode = resp.StatusCode
+		errResp.BucketName = bucketName
+		return errResp
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package s3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// transport returns the transport of a client. Clients are reused by
+// ClientCache, so it keeps enough idle connections to the endpoint for
+// concurrent requests instead of opening new ones.
+func (cfg *Config) transport() (*http.Transport, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		Proxy:                 cfg.Proxy.proxyFunc(),
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		// Objects are read as they are stored, even with a gzip encoding
+		DisableCompression: true,
+	}, nil
+}
+
+// tlsConfig returns the TLS configuration for the endpoints, or nil for the
+// default one
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	if !cfg.Insecure && cfg.CABundle == "" && cfg.TLSClientCert == "" && cfg.TLSClientKey == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	tlsConfig.InsecureSkipVerify = cfg.Insecure
+	if cfg.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(cfg.CABundle)) {
+			return nil, fmt.Errorf("caBundle contains no PEM encoded certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.TLSClientCert != "" || cfg.TLSClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(cfg.TLSClientCert), []byte(cfg.TLSClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tlsClientCert or tlsClientKey: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,83 @@
+package s3_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"git.gmem.ca/arch/k8s-csi-s3/pkg/s3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS", func() {
+	var (
+		server   *httptest.Server
+		caBundle string
+	)
+
+	BeforeEach(func() {
+		// Every bucket exists, in the default region
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Has("location") {
+				fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+			}
+		}))
+		server.TLS = &tls.Config{}
+		server.StartTLS()
+		caBundle = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fails to verify an unknown CA", func() {
+		client, err := s3.NewClient(&s3.Config{Endpoint: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.BucketExists(context.Background(), "bucket")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("trusts the CA bundle", func() {
+		client, err := s3.NewClient(&s3.Config{Endpoint: server.URL, CABundle: caBundle})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.BucketExists(context.Background(), "bucket")).To(BeTrue())
+	})
+
+	It("skips verification when insecure", func() {
+		client, err := s3.NewClient(&s3.Config{Endpoint: server.URL, Insecure: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.BucketExists(context.Background(), "bucket")).To(BeTrue())
+	})
+
+	It("rejects a CA bundle without certificates", func() {
+		_, err := s3.NewClient(&s3.Config{Endpoint: server.URL, CABundle: "not a certificate"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("presents the client certificate", func() {
+		// The test server's certificate is reused as the client's one, which
+		// it can't verify, so only its presence is checked
+		server.TLS.ClientAuth = tls.RequireAnyClientCert
+		key, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := s3.NewClient(&s3.Config{
+			Endpoint:      server.URL,
+			CABundle:      caBundle,
+			TLSClientCert: caBundle,
+			TLSClientKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.BucketExists(context.Background(), "bucket")).To(BeTrue())
+	})
+
+	It("rejects a client certificate without its key", func() {
+		_, err := s3.NewClient(&s3.Config{Endpoint: server.URL, TLSClientCert: caBundle})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/minio/minio-go/v7"
+)
+
+// maxLockChecks is how many objects of a failed batch are checked for object
+// lock before it's retried
+const maxLockChecks = 10
+
+// versioned tells whether a bucket keeps versions of its objects. A bucket
+// whose versioning is suspended still has the versions written before.
+func (client *s3Client) versioned(ctx context.Context, bucketName string) (bool, error) {
+	cfg, err := client.minio.GetBucketVersioning(ctx, bucketName)
+	if err != nil {
+		switch ClassifyError(err) {
+		case ErrNotFound, ErrCanceled, ErrDeadlineExceeded, ErrUnavailable:
+			return false, err
+		}
+		// Endpoints without versioning may not implement the request
+		glog.V(4).Infof("Failed to get versioning of bucket %s, assuming it has none: %v", bucketName, err)
+		return false, nil
+	}
+	return cfg.Enabled() || cfg.Suspended(), nil
+}
+
+// removeKey removes an object, with all its versions and delete markers if
+// versions is set
+func (client *s3Client) removeKey(ctx context.Context, bucketName, key string, versions bool, opts RemoveOptions) error {
+	if !versions {
+		return client.minio.RemoveObject(ctx, bucketName, key, minio.RemoveObjectOptions{
+			GovernanceBypass: opts.GovernanceBypass,
+		})
+	}
+	var objects []minio.ObjectInfo
+	for object := range client.minio.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       key,
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if object.Key == key {
+			objects = append(objects, object)
+		}
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+	return client.removeBatch(ctx, bucketName, objects, opts)
+}
+
+// objectLock returns an ObjectLockedError if a version of an object can't be
+// removed because of a legal hold or retention
+func (client *s3Client) objectLock(ctx context.Context, bucketName string, object minio.ObjectInfo, governanceBypass bool) error {
+	if object.IsDeleteMarker {
+		return nil
+	}
+	hold, err := client.minio.GetObjectLegalHold(ctx, bucketName, object.Key,
+		minio.GetObjectLegalHoldOptions{VersionID: object.VersionID})
+	if err == nil && hold != nil && *hold == minio.LegalHoldEnabled {
+		return &ObjectLockedError{Bucket: bucketName, Key: object.Key, VersionID: object.VersionID, LegalHold: true}
+	}
+	mode, until, err := client.minio.GetObjectRetention(ctx, bucketName, object.Key, object.VersionID)
+	if err != nil || mode == nil || until == nil || !until.After(time.Now()) {
+		return nil
+	}
+	if *mode == minio.Governance && governanceBypass {
+		return nil
+	}
+	return &ObjectLockedError{
+		Bucket:        bucketName,
+		Key:           object.Key,
+		VersionID:     object.VersionID,
+		RetentionMode: *mode,
+		RetainUntil:   *until,
+	}
+}